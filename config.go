@@ -4,15 +4,25 @@
 package datalayersgrpcexporter
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emqx-ecp-devops/datalayersgrpcexporter/internal/otel2datalayers"
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/configopaque"
 	"go.opentelemetry.io/collector/config/configretry"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 	"golang.org/x/exp/maps"
 )
 
 type Trace struct {
+	// Database is the Datalayers database the span tables are created in.
+	Database string `mapstructure:"database"`
+	// Table is the table spans are written to when no custom trace matches.
 	Table string `mapstructure:"table"`
 	// SpanDimensions are span attributes to be used as line protocol tags.
 	// These are always included as tags:
@@ -25,7 +35,7 @@ type Trace struct {
 	// - https://opentelemetry.io/docs/specs/semconv/
 	SpanDimensions []string `mapstructure:"span_dimensions"`
 	// SpanFields are span attributes to be used as line protocol fields.
-	// SpanFields can be empty.
+	// SpanFields can be empty, and must not contain any of the SpanDimensions.
 	SpanFields     []string      `mapstructure:"span_fields"`
 	CustomKeyScope string        `mapstructure:"custom_key_scope"`
 	Custom         []CustomTrace `mapstructure:"custom"`
@@ -38,11 +48,74 @@ type CustomTrace struct {
 	SpanFields     []string `mapstructure:"span_fields"`
 }
 
+type Log struct {
+	// Database is the Datalayers database the log table is created in.
+	Database string `mapstructure:"database"`
+	// Table is the table log records are written to.
+	Table string `mapstructure:"table"`
+	// LogRecordDimensions are log record or resource attributes to be used as tag columns.
+	// At least one dimension is required, as the tag columns are the partition keys of the table.
+	LogRecordDimensions []string `mapstructure:"log_record_dimensions"`
+}
+
+// MetricsRouting defines the database and table metrics are written to. The names are templates
+// referencing the metric with ${...} variables: ${resource.<key>}, ${scope.name}, ${scope.version},
+// ${metric.name}, ${metric.type} and ${metric.unit}. In collector configuration files, escape them
+// as $${...} so that they are not expanded as environment variables.
+type MetricsRouting struct {
+	// Database is the template of the database name.
+	Database string `mapstructure:"database"`
+	// Table is the template of the table name. It is only used by the telegraf-prometheus-v1 schema,
+	// the other schemas name their tables after their layout.
+	Table string `mapstructure:"table"`
+	// FallbackDatabase is the database used when the database template references a missing key.
+	// If it is empty, such metrics are dropped.
+	FallbackDatabase string `mapstructure:"fallback_database"`
+	// Rules override the templates of the metrics they match. The first matching rule applies.
+	Rules []MetricsRoutingRule `mapstructure:"rules"`
+}
+
+type MetricsRoutingRule struct {
+	// MetricName is a regular expression the metric name must match.
+	MetricName string `mapstructure:"metric_name"`
+	// ResourceAttributes must all be present on the resource with the same values.
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
+	// Database is the template of the database name of the matched metrics.
+	Database string `mapstructure:"database"`
+	// Table is the template of the table name of the matched metrics.
+	Table string `mapstructure:"table"`
+}
+
+// Limits guard the tables against the attributes with unbounded keys or values, such as request IDs
+// used as attribute keys. The lines exceeding a limit are reported by the exporter telemetry.
+type Limits struct {
+	// MaxColumnsPerTable is the maximum number of tag and value columns of a table.
+	// The columns over it are not created, their values being handled according to Overflow.
+	// Zero disables the limit.
+	MaxColumnsPerTable int `mapstructure:"max_columns_per_table"`
+	// MaxSeriesPerTable is the maximum number of distinct series, i.e. combinations of tag values,
+	// written to a metrics table per SeriesInterval. The lines of the other series are dropped, whatever
+	// Overflow. It does not apply to the spans and log records.
+	// Zero, the default, disables the limit.
+	MaxSeriesPerTable int `mapstructure:"max_series_per_table"`
+	// SeriesInterval is the interval the series of a table are counted over.
+	SeriesInterval time.Duration `mapstructure:"series_interval"`
+	// MaxAttributeValueLength is the maximum length, in bytes, of the tag values. Longer values are truncated.
+	// Zero disables the limit.
+	MaxAttributeValueLength int `mapstructure:"max_attribute_value_length"`
+	// Overflow defines how the values of the columns over the column limit are written.
+	// Options:
+	// - fold: written to the `extra_attributes` JSON column instead
+	// - drop: dropped
+	Overflow string `mapstructure:"overflow"`
+}
+
 // Config defines configuration for the InfluxDB exporter.
 type Config struct {
-	// confighttp.ClientConfig   `mapstructure:",squash"`
-	QueueSettings             exporterhelper.QueueSettings `mapstructure:"sending_queue"`
-	configretry.BackOffConfig `mapstructure:"retry_on_failure"`
+	configgrpc.ClientConfig        `mapstructure:",squash"`
+	exporterhelper.TimeoutSettings `mapstructure:",squash"`
+	QueueSettings                  exporterhelper.QueueSettings `mapstructure:"sending_queue"`
+	configretry.BackOffConfig      `mapstructure:"retry_on_failure"`
 
 	// // Org is the InfluxDB organization name of the destination bucket.
 	// Org string `mapstructure:"org"`
@@ -57,33 +130,140 @@ type Config struct {
 	// Port is the InfluxDB server port.
 	Port uint32 `mapstructure:"port"`
 
-	// TlsCertPath is the path to the TLS certificate to use for HTTPS requests.
+	// Endpoints are the host:port addresses of the Datalayers nodes.
+	// When set, Endpoint, Host and Port are ignored; otherwise Endpoint, when set, is used instead of Host and Port.
+	Endpoints []string `mapstructure:"endpoints"`
+	// LoadBalancing defines how the writes are spread over the endpoints.
+	// Options:
+	// - round_robin: each endpoint in turn
+	// - least_loaded: the endpoint serving the fewest requests
+	LoadBalancing string `mapstructure:"load_balancing"`
+
+	// MaxSendMsgSizeMiB is the maximum size of the messages sent to Datalayers. Zero uses the gRPC default.
+	MaxSendMsgSizeMiB int `mapstructure:"max_send_msg_size_mib"`
+	// MaxRecvMsgSizeMiB is the maximum size of the messages received from Datalayers. Zero uses the gRPC default.
+	MaxRecvMsgSizeMiB int `mapstructure:"max_recv_msg_size_mib"`
+
+	// TlsCertPath is the path to the CA certificate of the server, enabling TLS.
+	// Deprecated: use tls::ca_file instead. TLS is configured by `tls`, it is disabled by default, see `insecure`.
 	TlsCertPath string `mapstructure:"tls_cert_path"`
 
 	// PartitionNum is the number of partitions to use for partitioning.
 	PartitionNum int `mapstructure:"partition_num"`
-	// Username is used to optionally specify the basic auth username
+	// Username is used to optionally specify the basic auth username.
+	// When empty, the basic auth is skipped, e.g. to authenticate with an `auth` extension instead.
 	Username string `mapstructure:"username"`
 	// Password is used to optionally specify the basic auth password
-	Password string `mapstructure:"password"`
+	Password configopaque.String `mapstructure:"password"`
+	// PasswordFile is the file the basic auth password is read from, instead of Password.
+	// It is read again every time the exporter authenticates, so that the password can be rotated.
+	PasswordFile string `mapstructure:"password_file"`
 
 	Trace Trace `mapstructure:"trace"`
+	Log   Log   `mapstructure:"log"`
 	// PayloadMaxLines is the maximum number of line protocol lines to POST in a single request.
 	PayloadMaxLines int `mapstructure:"payload_max_lines"`
 	// PayloadMaxBytes is the maximum number of line protocol bytes to POST in a single request.
 	PayloadMaxBytes int `mapstructure:"payload_max_bytes"`
 
-	// MetricsSchema indicates the metrics schema to emit to line protocol.
+	// MetricsSchema indicates the table layout metrics are written with.
 	// Options:
-	// - telegraf-prometheus-v1
-	// - telegraf-prometheus-v2
+	// - telegraf-prometheus-v1: one table per metric, value columns named after the metric type
+	// - telegraf-prometheus-v2: a single `prometheus` table, the series name in the `__name__` tag
+	// - otel-v1: one table per metric type, with the columns of the OpenTelemetry data model
 	MetricsSchema string `mapstructure:"metrics_schema"`
+	// MetricsRouting defines the database and table metrics are written to.
+	MetricsRouting MetricsRouting `mapstructure:"metrics_routing"`
 
 	// TTL is the TTL of datalayers's table. the uint is the number of hours.
 	TTL int `mapstructure:"ttl"`
+
+	// TimestampPrecision is the precision of the timestamp columns of the created tables.
+	// Options: s, ms, us, ns. Existing tables keep the precision they were created with.
+	TimestampPrecision string `mapstructure:"timestamp_precision"`
+	// ZeroTimestampPolicy defines how metric data points without a timestamp are written.
+	// Options:
+	// - now: written with the time they are inserted at
+	// - drop: dropped
+	ZeroTimestampPolicy string `mapstructure:"zero_timestamp_policy"`
+	// StartTimestamp writes the start time of the cumulative sums and histograms to the `start_ts` column
+	// of their tables. It is disabled by default, as it adds the column to the tables of these metrics.
+	StartTimestamp bool `mapstructure:"start_timestamp"`
+	// TypeConflictPolicy defines how a value is written when it does not match the type of its column,
+	// e.g. a string attribute written to the BIGINT column created for the int values of the attribute.
+	// Options:
+	// - coerce: converted to the type of the column when possible, e.g. "200" to 200, written as null otherwise
+	// - discard: written as null
+	TypeConflictPolicy string `mapstructure:"type_conflict_policy"`
+
+	// NumWorkers is the number of batches written to Datalayers in parallel.
+	// The batches of a table are always written by the same worker, in order.
+	NumWorkers int `mapstructure:"num_workers"`
+	// ShutdownTimeout is how long the exporter waits on shutdown for the pending batches to be written.
+	// The batches still pending after it are dropped.
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	// Limits guard the tables against the attributes with unbounded keys or values.
+	Limits Limits `mapstructure:"limits"`
 }
 
 func (cfg *Config) Validate() error {
+	if _, found := otel2datalayers.MetricsSchemata[cfg.MetricsSchema]; !found {
+		return fmt.Errorf("invalid metrics schema %q, valid values are: %s", cfg.MetricsSchema,
+			strings.Join(maps.Keys(otel2datalayers.MetricsSchemata), ", "))
+	}
+	if _, err := cfg.metricsRouter(); err != nil {
+		return fmt.Errorf("invalid metrics routing: %w", err)
+	}
+	if _, found := otel2datalayers.TimestampPrecisions[cfg.TimestampPrecision]; !found {
+		return fmt.Errorf("invalid timestamp precision %q, valid values are: %s", cfg.TimestampPrecision,
+			strings.Join(maps.Keys(otel2datalayers.TimestampPrecisions), ", "))
+	}
+	if _, found := otel2datalayers.ZeroTimestampPolicies[cfg.ZeroTimestampPolicy]; !found {
+		return fmt.Errorf("invalid zero timestamp policy %q, valid values are: %s", cfg.ZeroTimestampPolicy,
+			strings.Join(maps.Keys(otel2datalayers.ZeroTimestampPolicies), ", "))
+	}
+	if _, found := otel2datalayers.LoadBalancings[cfg.LoadBalancing]; !found {
+		return fmt.Errorf("invalid load balancing %q, valid values are: %s", cfg.LoadBalancing,
+			strings.Join(maps.Keys(otel2datalayers.LoadBalancings), ", "))
+	}
+	for _, endpoint := range cfg.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+		}
+	}
+	if cfg.Password != "" && cfg.PasswordFile != "" {
+		return errors.New("password and password_file must not be both set")
+	}
+	if cfg.MaxSendMsgSizeMiB < 0 || cfg.MaxRecvMsgSizeMiB < 0 {
+		return errors.New("max_send_msg_size_mib and max_recv_msg_size_mib must not be negative")
+	}
+	if cfg.NumWorkers < 1 {
+		return errors.New("num_workers must be at least 1")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return errors.New("shutdown_timeout must be positive")
+	}
+	if _, found := otel2datalayers.TypeConflictPolicies[cfg.TypeConflictPolicy]; !found {
+		return fmt.Errorf("invalid type conflict policy %q, valid values are: %s", cfg.TypeConflictPolicy,
+			strings.Join(maps.Keys(otel2datalayers.TypeConflictPolicies), ", "))
+	}
+	if cfg.Limits.MaxColumnsPerTable < 0 || cfg.Limits.MaxSeriesPerTable < 0 || cfg.Limits.MaxAttributeValueLength < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if cfg.Limits.MaxSeriesPerTable > 0 && cfg.Limits.SeriesInterval <= 0 {
+		return errors.New("limits::series_interval must be positive")
+	}
+	if _, found := otel2datalayers.OverflowPolicies[cfg.Limits.Overflow]; !found {
+		return fmt.Errorf("invalid limits overflow %q, valid values are: %s", cfg.Limits.Overflow,
+			strings.Join(maps.Keys(otel2datalayers.OverflowPolicies), ", "))
+	}
+	if cfg.Trace.Database == "" {
+		return errors.New("trace database must not be empty")
+	}
+	if cfg.Trace.Table == "" {
+		return errors.New("trace table must not be empty")
+	}
 	globalSpanTags := make(map[string]struct{}, len(cfg.Trace.SpanDimensions))
 	globalSpanFields := make(map[string]struct{}, len(cfg.Trace.SpanDimensions))
 	duplicateTags := make(map[string]struct{})
@@ -101,7 +281,7 @@ func (cfg *Config) Validate() error {
 	duplicateFields := make(map[string]struct{})
 	for _, k := range cfg.Trace.SpanFields {
 		if _, found := globalSpanFields[k]; found {
-			duplicateTags[k] = struct{}{}
+			duplicateFields[k] = struct{}{}
 		} else {
 			globalSpanFields[k] = struct{}{}
 		}
@@ -110,6 +290,31 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("duplicate span fields(s) configured: %s",
 			strings.Join(maps.Keys(duplicateFields), ","))
 	}
+	if err := checkSpanColumns(cfg.Trace.SpanDimensions, cfg.Trace.SpanFields); err != nil {
+		return err
+	}
+	if cfg.Log.Database == "" {
+		return errors.New("log database must not be empty")
+	}
+	if cfg.Log.Table == "" {
+		return errors.New("log table must not be empty")
+	}
+	if len(cfg.Log.LogRecordDimensions) == 0 {
+		return errors.New("log record dimensions must not be empty")
+	}
+	duplicateDimensions := make(map[string]struct{})
+	logDimensions := make(map[string]struct{}, len(cfg.Log.LogRecordDimensions))
+	for _, k := range cfg.Log.LogRecordDimensions {
+		if _, found := logDimensions[k]; found {
+			duplicateDimensions[k] = struct{}{}
+		} else {
+			logDimensions[k] = struct{}{}
+		}
+	}
+	if len(duplicateDimensions) > 0 {
+		return fmt.Errorf("duplicate log record dimension(s) configured: %s",
+			strings.Join(maps.Keys(duplicateDimensions), ","))
+	}
 	if len(cfg.Trace.Custom) > 0 {
 		// validate custom_key_scope
 		// valid values: name, kind, parent_span_id, status_code, context.trace_id, context.span_id, attributes.xxx
@@ -126,6 +331,9 @@ func (cfg *Config) Validate() error {
 		customKeys := make(map[string]struct{})
 		duplicateKeys := make(map[string]struct{})
 		for _, custom := range cfg.Trace.Custom {
+			if custom.Table == "" {
+				return fmt.Errorf("custom trace table must not be empty, keys: %s", strings.Join(custom.Key, ","))
+			}
 			keyArray := custom.Key
 			for _, k := range keyArray {
 				if _, found := customKeys[k]; found {
@@ -160,6 +368,9 @@ func (cfg *Config) Validate() error {
 				return fmt.Errorf("duplicate custom span fields(s) configured: %s",
 					strings.Join(maps.Keys(duplicateFields), ","))
 			}
+			if err := checkSpanColumns(custom.SpanDimensions, custom.SpanFields); err != nil {
+				return fmt.Errorf("custom trace %s: %w", custom.Table, err)
+			}
 		}
 		if len(duplicateKeys) > 0 {
 			return fmt.Errorf("duplicate custom key configured: %s",
@@ -169,3 +380,58 @@ func (cfg *Config) Validate() error {
 
 	return nil
 }
+
+// checkSpanColumns returns an error if keys are both span dimensions and span fields: a key can
+// only be written to a tag column or to a value column.
+func checkSpanColumns(dimensions, fields []string) error {
+	tags := make(map[string]struct{}, len(dimensions))
+	for _, k := range dimensions {
+		tags[k] = struct{}{}
+	}
+	var both []string
+	for _, k := range fields {
+		if _, found := tags[k]; found {
+			both = append(both, k)
+		}
+	}
+	if len(both) > 0 {
+		return fmt.Errorf("span dimension(s) also configured as span fields: %s", strings.Join(both, ","))
+	}
+	return nil
+}
+
+func (cfg *Config) metricsRouter() (*otel2datalayers.MetricsRouter, error) {
+	routing := cfg.MetricsRouting
+	rules := make([]otel2datalayers.MetricsRoutingRule, 0, len(routing.Rules))
+	for _, rule := range routing.Rules {
+		rules = append(rules, otel2datalayers.MetricsRoutingRule{
+			MetricName:         rule.MetricName,
+			ResourceAttributes: rule.ResourceAttributes,
+			Database:           rule.Database,
+			Table:              rule.Table,
+		})
+	}
+	return otel2datalayers.NewMetricsRouter(routing.Database, routing.Table, routing.FallbackDatabase, rules)
+}
+
+// endpoints returns the addresses of the Datalayers nodes.
+func (cfg *Config) endpoints() []string {
+	if len(cfg.Endpoints) > 0 {
+		return cfg.Endpoints
+	}
+	if cfg.Endpoint != "" {
+		return []string{cfg.Endpoint}
+	}
+	return []string{net.JoinHostPort(cfg.Host, strconv.FormatUint(uint64(cfg.Port), 10))}
+}
+
+// grpcClientConfig returns the gRPC settings of the connections, tls_cert_path enabling TLS
+// with its CA certificate when tls::ca_file is not set.
+func (cfg *Config) grpcClientConfig() *configgrpc.ClientConfig {
+	grpcConfig := cfg.ClientConfig
+	if cfg.TlsCertPath != "" && grpcConfig.TLSSetting.CAFile == "" {
+		grpcConfig.TLSSetting.CAFile = cfg.TlsCertPath
+		grpcConfig.TLSSetting.Insecure = false
+	}
+	return &grpcConfig
+}
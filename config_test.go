@@ -0,0 +1,377 @@
+package datalayersgrpcexporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emqx-ecp-devops/datalayersgrpcexporter/internal/metadata"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.opentelemetry.io/collector/confmap"
+)
+
+// testFileProvider reads the configuration files, as the file provider of the collector does.
+type testFileProvider struct{}
+
+func (testFileProvider) Retrieve(_ context.Context, uri string, _ confmap.WatcherFunc) (*confmap.Retrieved, error) {
+	content, err := os.ReadFile(filepath.Clean(strings.TrimPrefix(uri, "file:")))
+	if err != nil {
+		return nil, err
+	}
+	return confmap.NewRetrievedFromYAML(content)
+}
+
+func (testFileProvider) Scheme() string { return "file" }
+
+func (testFileProvider) Shutdown(context.Context) error { return nil }
+
+// loadConfig resolves the configuration file as the collector does, e.g. unescaping $${...}.
+func loadConfig(t *testing.T, file string) *confmap.Conf {
+	t.Helper()
+	resolver, err := confmap.NewResolver(confmap.ResolverSettings{
+		URIs: []string{"file:" + file},
+		ProviderFactories: []confmap.ProviderFactory{
+			confmap.NewProviderFactory(func(confmap.ProviderSettings) confmap.Provider { return testFileProvider{} }),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestLoadConfig(t *testing.T) {
+	conf := loadConfig(t, filepath.Join("testdata", "config.yaml"))
+
+	override := createDefaultConfig().(*Config)
+	override.Endpoint = "http://localhost:8361"
+	override.Username = "admin"
+	override.Password = "public"
+	override.Timeout = 500 * time.Millisecond
+	override.Trace = Trace{
+		Database:       "traces",
+		Table:          "spans",
+		SpanDimensions: []string{"service.name", "trace_id"},
+		SpanFields:     []string{},
+		CustomKeyScope: "name",
+		Custom: []CustomTrace{
+			{Key: []string{"emqx", "neuronex"}, Table: "emqx", SpanDimensions: []string{"service.name", "client_id"}, SpanFields: []string{"attr1"}},
+			{Key: []string{"ecp"}, Table: "ecp", SpanDimensions: []string{"service.name"}},
+		},
+	}
+	override.MetricsRouting = MetricsRouting{
+		Database:         "metrics_${resource.service.name}",
+		Table:            "${metric.name}",
+		FallbackDatabase: "metrics_default",
+		Rules: []MetricsRoutingRule{{
+			MetricName:         `^http\.`,
+			ResourceAttributes: map[string]string{"deployment.environment": "staging"},
+			Database:           "staging_http",
+		}},
+	}
+	override.Log = Log{Database: "logs", Table: "logs", LogRecordDimensions: []string{"service.name", "host.name"}}
+	override.Endpoints = []string{"datalayers-0:8360", "datalayers-1:8360"}
+	override.LoadBalancing = "least_loaded"
+	override.TLSSetting = configtls.ClientConfig{
+		Config: configtls.Config{
+			CAFile:   "/etc/datalayers/ca.pem",
+			CertFile: "/etc/datalayers/client.pem",
+			KeyFile:  "/etc/datalayers/client-key.pem",
+		},
+		ServerName: "datalayers.internal",
+	}
+	override.Compression = "zstd"
+	override.Keepalive = &configgrpc.KeepaliveClientConfig{Time: 30 * time.Second, Timeout: 10 * time.Second, PermitWithoutStream: true}
+	override.Headers = map[string]configopaque.String{"x-datalayers-tenant": "demo"}
+	override.MaxSendMsgSizeMiB = 16
+	override.MaxRecvMsgSizeMiB = 16
+	override.TypeConflictPolicy = "discard"
+	override.NumWorkers = 8
+	override.ShutdownTimeout = 30 * time.Second
+	override.Limits = Limits{
+		MaxColumnsPerTable:      200,
+		MaxSeriesPerTable:       10000,
+		SeriesInterval:          10 * time.Minute,
+		MaxAttributeValueLength: 1024,
+		Overflow:                "drop",
+	}
+	override.PayloadMaxLines = 72
+	override.PayloadMaxBytes = 27
+
+	tests := []struct {
+		id   component.ID
+		want *Config
+	}{
+		{id: component.NewIDWithName(metadata.Type, "default-config"), want: createDefaultConfig().(*Config)},
+		{id: component.NewIDWithName(metadata.Type, "override-config"), want: override},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id.String(), func(t *testing.T) {
+			cfg := NewFactory().CreateDefaultConfig()
+			sub, err := conf.Sub(tt.id.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sub.Unmarshal(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := component.ValidateConfig(cfg); err != nil {
+				t.Errorf("ValidateConfig() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(cfg, tt.want) {
+				t.Errorf("config = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrpcClientConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantTLS configtls.ClientConfig
+	}{
+		{
+			name:    "insecure by default",
+			modify:  func(*Config) {},
+			wantTLS: configtls.ClientConfig{Insecure: true},
+		},
+		{
+			name:    "tls_cert_path",
+			modify:  func(cfg *Config) { cfg.TlsCertPath = "/etc/datalayers/ca.pem" },
+			wantTLS: configtls.ClientConfig{Config: configtls.Config{CAFile: "/etc/datalayers/ca.pem"}},
+		},
+		{
+			name: "tls::ca_file over tls_cert_path",
+			modify: func(cfg *Config) {
+				cfg.TlsCertPath = "/etc/datalayers/old-ca.pem"
+				cfg.TLSSetting = configtls.ClientConfig{Config: configtls.Config{CAFile: "/etc/datalayers/ca.pem"}, ServerName: "datalayers.internal"}
+			},
+			wantTLS: configtls.ClientConfig{Config: configtls.Config{CAFile: "/etc/datalayers/ca.pem"}, ServerName: "datalayers.internal"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.modify(cfg)
+			tls := cfg.TLSSetting
+
+			grpcConfig := cfg.grpcClientConfig()
+			if !reflect.DeepEqual(grpcConfig.TLSSetting, tt.wantTLS) {
+				t.Errorf("grpcClientConfig() TLS = %+v, want %+v", grpcConfig.TLSSetting, tt.wantTLS)
+			}
+			if !reflect.DeepEqual(cfg.TLSSetting, tls) {
+				t.Errorf("grpcClientConfig() modified the config TLS to %+v", cfg.TLSSetting)
+			}
+		})
+	}
+}
+
+func TestValidateSpanDimensionsAndFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{
+			name:   "distinct dimensions and fields",
+			modify: func(cfg *Config) { cfg.Trace.SpanFields = []string{"http.method"} },
+		},
+		{
+			name:    "dimension also a field",
+			modify:  func(cfg *Config) { cfg.Trace.SpanFields = []string{"http.method", "service.name"} },
+			wantErr: "span dimension(s) also configured as span fields: service.name",
+		},
+		{
+			name: "custom dimension also a field",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans", SpanDimensions: []string{"http.route"}, SpanFields: []string{"http.route"}}}
+			},
+			wantErr: "custom trace get_spans: span dimension(s) also configured as span fields: http.route",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{name: "default", modify: func(*Config) {}},
+		{name: "metrics schema", modify: func(cfg *Config) { cfg.MetricsSchema = "influx" }, wantErr: `invalid metrics schema "influx"`},
+		{name: "metrics routing", modify: func(cfg *Config) { cfg.MetricsRouting.Database = "" }, wantErr: "invalid metrics routing: database template is empty"},
+		{
+			name:    "metrics routing rule",
+			modify:  func(cfg *Config) { cfg.MetricsRouting.Rules = []MetricsRoutingRule{{MetricName: "(", Database: "db"}} },
+			wantErr: "invalid metrics routing: invalid metric_name of routing rule 0",
+		},
+		{name: "timestamp precision", modify: func(cfg *Config) { cfg.TimestampPrecision = "m" }, wantErr: `invalid timestamp precision "m"`},
+		{name: "zero timestamp policy", modify: func(cfg *Config) { cfg.ZeroTimestampPolicy = "keep" }, wantErr: `invalid zero timestamp policy "keep"`},
+		{name: "load balancing", modify: func(cfg *Config) { cfg.LoadBalancing = "random" }, wantErr: `invalid load balancing "random"`},
+		{name: "endpoint", modify: func(cfg *Config) { cfg.Endpoints = []string{"datalayers-0:8360", "datalayers-1"} }, wantErr: `invalid endpoint "datalayers-1"`},
+		{
+			name: "password and password file",
+			modify: func(cfg *Config) {
+				cfg.Username, cfg.Password, cfg.PasswordFile = "admin", "public", "/run/secrets/datalayers"
+			},
+			wantErr: "password and password_file must not be both set",
+		},
+		{
+			name:   "password file",
+			modify: func(cfg *Config) { cfg.Username, cfg.PasswordFile = "admin", "/run/secrets/datalayers" },
+		},
+		{name: "max send message size", modify: func(cfg *Config) { cfg.MaxSendMsgSizeMiB = -1 }, wantErr: "max_send_msg_size_mib and max_recv_msg_size_mib must not be negative"},
+		{name: "max receive message size", modify: func(cfg *Config) { cfg.MaxRecvMsgSizeMiB = -1 }, wantErr: "max_send_msg_size_mib and max_recv_msg_size_mib must not be negative"},
+		{name: "num workers", modify: func(cfg *Config) { cfg.NumWorkers = 0 }, wantErr: "num_workers must be at least 1"},
+		{name: "shutdown timeout", modify: func(cfg *Config) { cfg.ShutdownTimeout = 0 }, wantErr: "shutdown_timeout must be positive"},
+		{name: "type conflict policy", modify: func(cfg *Config) { cfg.TypeConflictPolicy = "fail" }, wantErr: `invalid type conflict policy "fail"`},
+		{name: "negative limit", modify: func(cfg *Config) { cfg.Limits.MaxColumnsPerTable = -1 }, wantErr: "limits must not be negative"},
+		{
+			name:    "series interval",
+			modify:  func(cfg *Config) { cfg.Limits.MaxSeriesPerTable, cfg.Limits.SeriesInterval = 100, 0 },
+			wantErr: "limits::series_interval must be positive",
+		},
+		{name: "overflow", modify: func(cfg *Config) { cfg.Limits.Overflow = "truncate" }, wantErr: `invalid limits overflow "truncate"`},
+		{name: "trace database", modify: func(cfg *Config) { cfg.Trace.Database = "" }, wantErr: "trace database must not be empty"},
+		{name: "trace table", modify: func(cfg *Config) { cfg.Trace.Table = "" }, wantErr: "trace table must not be empty"},
+		{
+			name:    "duplicate span dimension",
+			modify:  func(cfg *Config) { cfg.Trace.SpanDimensions = []string{"service.name", "service.name"} },
+			wantErr: "duplicate span dimension(s) configured: service.name",
+		},
+		{
+			name:    "duplicate span field",
+			modify:  func(cfg *Config) { cfg.Trace.SpanFields = []string{"http.method", "http.method"} },
+			wantErr: "duplicate span fields(s) configured: http.method",
+		},
+		{name: "log database", modify: func(cfg *Config) { cfg.Log.Database = "" }, wantErr: "log database must not be empty"},
+		{name: "log table", modify: func(cfg *Config) { cfg.Log.Table = "" }, wantErr: "log table must not be empty"},
+		{name: "log record dimensions", modify: func(cfg *Config) { cfg.Log.LogRecordDimensions = nil }, wantErr: "log record dimensions must not be empty"},
+		{
+			name:    "duplicate log record dimension",
+			modify:  func(cfg *Config) { cfg.Log.LogRecordDimensions = []string{"host.name", "host.name"} },
+			wantErr: "duplicate log record dimension(s) configured: host.name",
+		},
+		{
+			name: "custom key scope",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "resource.service.name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans"}}
+			},
+			wantErr: "invalid custom key scope resource.service.name",
+		},
+		{
+			name: "custom attribute key scope",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "attributes.http.method"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans"}}
+			},
+		},
+		{
+			name: "custom table",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}}}
+			},
+			wantErr: "custom trace table must not be empty, keys: GET",
+		},
+		{
+			name: "duplicate custom key",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans"}, {Key: []string{"GET"}, Table: "other_spans"}}
+			},
+			wantErr: "duplicate custom key configured: GET",
+		},
+		{
+			name: "duplicate custom span dimension",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans", SpanDimensions: []string{"http.route", "http.route"}}}
+			},
+			wantErr: "duplicate custom span dimension(s) configured: http.route",
+		},
+		{
+			name: "duplicate custom span field",
+			modify: func(cfg *Config) {
+				cfg.Trace.CustomKeyScope = "name"
+				cfg.Trace.Custom = []CustomTrace{{Key: []string{"GET"}, Table: "get_spans", SpanFields: []string{"http.route", "http.route"}}}
+			},
+			wantErr: "duplicate custom span fields(s) configured: http.route",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{name: "host and port", modify: func(*Config) {}, want: []string{"datalayers:6360"}},
+		{name: "IPv6 host", modify: func(cfg *Config) { cfg.Host = "::1" }, want: []string{"[::1]:6360"}},
+		{name: "endpoint", modify: func(cfg *Config) { cfg.Endpoint = "localhost:8360" }, want: []string{"localhost:8360"}},
+		{
+			name: "endpoints",
+			modify: func(cfg *Config) {
+				cfg.Endpoint = "localhost:8360"
+				cfg.Endpoints = []string{"datalayers-0:8360", "datalayers-1:8360"}
+			},
+			want: []string{"datalayers-0:8360", "datalayers-1:8360"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.modify(cfg)
+			if got := cfg.endpoints(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpoints() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,11 +5,14 @@ package datalayersgrpcexporter
 
 import (
 	"context"
+	"time"
 
 	"github.com/emqx-ecp-devops/datalayersgrpcexporter/internal/metadata"
 	"github.com/emqx-ecp-devops/datalayersgrpcexporter/internal/otel2datalayers"
 	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configgrpc"
 	"go.opentelemetry.io/collector/config/configretry"
+	"go.opentelemetry.io/collector/config/configtls"
 	"go.opentelemetry.io/collector/exporter"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 )
@@ -19,23 +22,54 @@ func NewFactory() exporter.Factory {
 	return exporter.NewFactory(
 		metadata.Type,
 		createDefaultConfig,
-		// exporter.WithTraces(createTraceExporter, metadata.TracesStability),
+		exporter.WithTraces(createTraceExporter, metadata.TracesStability),
 		exporter.WithMetrics(createMetricsExporter, metadata.MetricsStability),
-		// exporter.WithLogs(createLogsExporter, metadata.LogsStability),
+		exporter.WithLogs(createLogsExporter, metadata.LogsStability),
 	)
 }
 
 func createDefaultConfig() component.Config {
 	return &Config{
-		Host:          "datalayers",
-		Port:          6360,
-		QueueSettings: exporterhelper.NewDefaultQueueSettings(),
-		BackOffConfig: configretry.NewDefaultBackOffConfig(),
-		MetricsSchema: otel2datalayers.MetricsSchemaTelegrafPrometheusV1.String(),
-		// Trace: Trace{
-		// 	SpanDimensions: otel2influx.DefaultOtelTracesToLineProtocolConfig().GlobalTrace.SpanDimensions,
-		// },
-		// LogRecordDimensions: otel2influx.DefaultOtelLogsToLineProtocolConfig().LogRecordDimensions,
+		Host:                "datalayers",
+		Port:                6360,
+		LoadBalancing:       otel2datalayers.LoadBalancingRoundRobin.String(),
+		TimeoutSettings:     exporterhelper.NewDefaultTimeoutSettings(),
+		QueueSettings:       exporterhelper.NewDefaultQueueSettings(),
+		BackOffConfig:       configretry.NewDefaultBackOffConfig(),
+		MetricsSchema:       otel2datalayers.MetricsSchemaTelegrafPrometheusV1.String(),
+		TimestampPrecision:  "ms",
+		ZeroTimestampPolicy: otel2datalayers.ZeroTimestampPolicyNow.String(),
+		TypeConflictPolicy:  otel2datalayers.TypeConflictPolicyCoerce.String(),
+		NumWorkers:          4,
+		ShutdownTimeout:     10 * time.Second,
+		ClientConfig: configgrpc.ClientConfig{
+			TLSSetting: configtls.ClientConfig{
+				Insecure: true,
+			},
+			Keepalive:    configgrpc.NewDefaultKeepaliveClientConfig(),
+			BalancerName: configgrpc.BalancerName(),
+		},
+		Limits: Limits{
+			MaxColumnsPerTable:      500,
+			SeriesInterval:          time.Hour,
+			MaxAttributeValueLength: 4096,
+			Overflow:                otel2datalayers.OverflowPolicyFold.String(),
+		},
+		MetricsRouting: MetricsRouting{
+			Database:         "metrics_${resource.service.name}",
+			Table:            "${metric.name}",
+			FallbackDatabase: "metrics_default",
+		},
+		Trace: Trace{
+			Database:       "traces",
+			Table:          "spans",
+			SpanDimensions: []string{"service.name"},
+		},
+		Log: Log{
+			Database:            "logs",
+			Table:               "logs",
+			LogRecordDimensions: []string{"service.name"},
+		},
 		// defaults per suggested:
 		// https://docs.influxdata.com/influxdb/cloud-serverless/write-data/best-practices/optimize-writes/#batch-writes
 		PayloadMaxLines: 10_000,
@@ -43,62 +77,79 @@ func createDefaultConfig() component.Config {
 	}
 }
 
-// func createTraceExporter(
-// 	ctx context.Context,
-// 	set exporter.Settings,
-// 	config component.Config,
-// ) (exporter.Traces, error) {
-// 	cfg := config.(*Config)
-
-// 	logger := newZapInfluxLogger(set.Logger)
-
-// 	writer, err := newInfluxHTTPWriter(logger, cfg, set.TelemetrySettings)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	expConfig := otel2influx.DefaultOtelTracesToLineProtocolConfig()
-// 	expConfig.Logger = logger
-// 	expConfig.Writer = writer
-// 	trace := cfg.Trace
-// 	expConfig.GlobalTrace = otel2influx.Trace{
-// 		Table:          trace.Table,
-// 		SpanDimensions: trace.SpanDimensions,
-// 		SpanFields:     trace.SpanFields,
-// 	}
-// 	expConfig.CustomKeyScope = cfg.Trace.CustomKeyScope
-// 	customs := cfg.Trace.Custom
-// 	expConfig.CustomTraces = make(map[string]otel2influx.Trace, len(customs))
-// 	for _, custom := range customs {
-// 		customTrace := otel2influx.Trace{
-// 			Table:          custom.Table,
-// 			SpanDimensions: custom.SpanDimensions,
-// 			SpanFields:     custom.SpanFields,
-// 		}
-// 		for _, key := range custom.Key {
-// 			expConfig.CustomTraces[key] = customTrace
-// 		}
-// 	}
-// 	exp, err := otel2influx.NewOtelTracesToLineProtocol(expConfig)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	return exporterhelper.NewTracesExporter(
-// 		ctx,
-// 		set,
-// 		cfg,
-// 		exp.WriteTraces,
-// 		exporterhelper.WithQueue(cfg.QueueSettings),
-// 		exporterhelper.WithRetry(cfg.BackOffConfig),
-// 		exporterhelper.WithStart(writer.Start),
-// 	)
-// }
+func createTraceExporter(
+	ctx context.Context,
+	set exporter.Settings,
+	config component.Config,
+) (exporter.Traces, error) {
+	cfg := config.(*Config)
+
+	writer, err := newDatalayerWritter(cfg, set.TelemetrySettings, false)
+	if err != nil {
+		return nil, err
+	}
+
+	expConfig := &otel2datalayers.OtelTracesToDatalayersConfig{
+		Writer:   writer,
+		Database: cfg.Trace.Database,
+	}
+	trace := cfg.Trace
+	expConfig.GlobalTrace = otel2datalayers.Trace{
+		Table:          trace.Table,
+		SpanDimensions: trace.SpanDimensions,
+		SpanFields:     trace.SpanFields,
+	}
+	expConfig.CustomKeyScope = cfg.Trace.CustomKeyScope
+	customs := cfg.Trace.Custom
+	expConfig.CustomTraces = make(map[string]otel2datalayers.Trace, len(customs))
+	for _, custom := range customs {
+		customTrace := otel2datalayers.Trace{
+			Table:          custom.Table,
+			SpanDimensions: custom.SpanDimensions,
+			SpanFields:     custom.SpanFields,
+		}
+		for _, key := range custom.Key {
+			expConfig.CustomTraces[key] = customTrace
+		}
+	}
+	exp, err := otel2datalayers.NewOtelTracesToDatalayers(expConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return exporterhelper.NewTracesExporter(
+		ctx,
+		set,
+		cfg,
+		exp.WriteTraces,
+		exporterhelper.WithTimeout(cfg.TimeoutSettings),
+		exporterhelper.WithQueue(cfg.QueueSettings),
+		exporterhelper.WithRetry(cfg.BackOffConfig),
+		exporterhelper.WithStart(writer.Start),
+		exporterhelper.WithShutdown(writer.Shutdown),
+	)
+}
 
 func createMetricsExporter(ctx context.Context, set exporter.Settings, config component.Config) (exporter.Metrics, error) {
 	cfg := config.(*Config)
 
-	writer, err := newDatalayerWritter(cfg, set.TelemetrySettings)
+	writer, err := newDatalayerWritter(cfg, set.TelemetrySettings, true)
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := cfg.metricsRouter()
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := otel2datalayers.NewOtelMetricsToDatalayers(&otel2datalayers.OtelMetricsToDatalayersConfig{
+		Writer:              writer,
+		Schema:              otel2datalayers.MetricsSchemata[cfg.MetricsSchema],
+		ZeroTimestampPolicy: otel2datalayers.ZeroTimestampPolicies[cfg.ZeroTimestampPolicy],
+		StartTimestamp:      cfg.StartTimestamp,
+		Router:              router,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -107,53 +158,76 @@ func createMetricsExporter(ctx context.Context, set exporter.Settings, config co
 		ctx,
 		set,
 		cfg,
-		otel2datalayers.WriteMetrics,
+		exp.WriteMetrics,
+		exporterhelper.WithTimeout(cfg.TimeoutSettings),
+		exporterhelper.WithQueue(cfg.QueueSettings),
+		exporterhelper.WithRetry(cfg.BackOffConfig),
+		exporterhelper.WithStart(writer.Start),
+		exporterhelper.WithShutdown(writer.Shutdown),
+	)
+}
+
+func createLogsExporter(ctx context.Context, set exporter.Settings, config component.Config) (exporter.Logs, error) {
+	cfg := config.(*Config)
+
+	writer, err := newDatalayerWritter(cfg, set.TelemetrySettings, false)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := otel2datalayers.NewOtelLogsToDatalayers(&otel2datalayers.OtelLogsToDatalayersConfig{
+		Writer:              writer,
+		Database:            cfg.Log.Database,
+		Table:               cfg.Log.Table,
+		LogRecordDimensions: cfg.Log.LogRecordDimensions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return exporterhelper.NewLogsExporter(
+		ctx,
+		set,
+		cfg,
+		exp.WriteLogs,
+		exporterhelper.WithTimeout(cfg.TimeoutSettings),
 		exporterhelper.WithQueue(cfg.QueueSettings),
 		exporterhelper.WithRetry(cfg.BackOffConfig),
 		exporterhelper.WithStart(writer.Start),
+		exporterhelper.WithShutdown(writer.Shutdown),
 	)
 }
 
-// func createLogsExporter(ctx context.Context, set exporter.Settings, config component.Config) (exporter.Logs, error) {
-// 	cfg := config.(*Config)
-
-// 	logger := newZapInfluxLogger(set.Logger)
-
-// 	writer, err := newInfluxHTTPWriter(logger, cfg, set.TelemetrySettings)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	expConfig := otel2influx.DefaultOtelLogsToLineProtocolConfig()
-// 	expConfig.Logger = logger
-// 	expConfig.Writer = writer
-// 	expConfig.LogRecordDimensions = cfg.LogRecordDimensions
-// 	exp, err := otel2influx.NewOtelLogsToLineProtocol(expConfig)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	return exporterhelper.NewLogsExporter(
-// 		ctx,
-// 		set,
-// 		cfg,
-// 		exp.WriteLogs,
-// 		exporterhelper.WithQueue(cfg.QueueSettings),
-// 		exporterhelper.WithRetry(cfg.BackOffConfig),
-// 		exporterhelper.WithStart(writer.Start),
-// 	)
-// }
-
-func newDatalayerWritter(config *Config, telemetrySettings component.TelemetrySettings) (*otel2datalayers.DatalayerWritter, error) {
-	return otel2datalayers.NewDatalayerWritter(
-		config.Host,
-		config.Username,
-		config.Password,
-		config.TlsCertPath,
-		config.PartitionNum,
-		config.Port,
-		config.PayloadMaxLines,
-		config.PayloadMaxBytes,
-		telemetrySettings,
-		config.TTL)
+// newDatalayerWritter creates the writer of an exporter. The series limit only applies if limitSeries
+// is true: the spans and log records are tagged with their unique trace and span IDs, so that every
+// one of them is a new series.
+func newDatalayerWritter(config *Config, telemetrySettings component.TelemetrySettings, limitSeries bool) (*otel2datalayers.DatalayerWritter, error) {
+	maxSeriesPerTable := 0
+	if limitSeries {
+		maxSeriesPerTable = config.Limits.MaxSeriesPerTable
+	}
+	return otel2datalayers.NewDatalayerWritter(&otel2datalayers.DatalayerWritterConfig{
+		Endpoints:          config.endpoints(),
+		LoadBalancing:      otel2datalayers.LoadBalancings[config.LoadBalancing],
+		Username:           config.Username,
+		Password:           string(config.Password),
+		PasswordFile:       config.PasswordFile,
+		GRPC:               config.grpcClientConfig(),
+		MaxSendMsgSize:     config.MaxSendMsgSizeMiB * 1024 * 1024,
+		MaxRecvMsgSize:     config.MaxRecvMsgSizeMiB * 1024 * 1024,
+		PartitionNum:       config.PartitionNum,
+		PayloadMaxLines:    config.PayloadMaxLines,
+		PayloadMaxBytes:    config.PayloadMaxBytes,
+		TTL:                config.TTL,
+		TimestampPrecision: config.TimestampPrecision,
+		TypeConflictPolicy: otel2datalayers.TypeConflictPolicies[config.TypeConflictPolicy],
+		NumWorkers:         config.NumWorkers,
+		ShutdownTimeout:    config.ShutdownTimeout,
+
+		MaxColumnsPerTable:      config.Limits.MaxColumnsPerTable,
+		MaxSeriesPerTable:       maxSeriesPerTable,
+		SeriesInterval:          config.Limits.SeriesInterval,
+		MaxAttributeValueLength: config.Limits.MaxAttributeValueLength,
+		OverflowPolicy:          otel2datalayers.OverflowPolicies[config.Limits.Overflow],
+	}, telemetrySettings)
 }
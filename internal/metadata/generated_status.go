@@ -12,6 +12,7 @@ var (
 )
 
 const (
-	TracesStability = component.StabilityLevelAlpha
+	TracesStability  = component.StabilityLevelAlpha
 	MetricsStability = component.StabilityLevelBeta
+	LogsStability    = component.StabilityLevelAlpha
 )
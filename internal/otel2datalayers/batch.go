@@ -0,0 +1,454 @@
+package otel2datalayers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// TimestampPrecisions maps the configurable timestamp precisions to arrow time units.
+var TimestampPrecisions = map[string]arrow.TimeUnit{
+	"s":  arrow.Second,
+	"ms": arrow.Millisecond,
+	"us": arrow.Microsecond,
+	"ns": arrow.Nanosecond,
+}
+
+// timestampType returns the arrow type used to bind timestamps with the given unit.
+func timestampType(unit arrow.TimeUnit) *arrow.TimestampType {
+	return &arrow.TimestampType{Unit: unit, TimeZone: "UTC"}
+}
+
+// TypeConflictPolicy defines how a value not matching the type of its column is written.
+type TypeConflictPolicy uint8
+
+const (
+	// TypeConflictPolicyCoerce converts the value to the type of the column when it can be
+	// converted, e.g. 200 to "200" for a STRING column or "200" to 200 for a BIGINT column,
+	// and writes a null otherwise.
+	TypeConflictPolicyCoerce TypeConflictPolicy = iota
+	// TypeConflictPolicyDiscard discards the value, writing a null instead.
+	TypeConflictPolicyDiscard
+)
+
+func (p TypeConflictPolicy) String() string {
+	switch p {
+	case TypeConflictPolicyCoerce:
+		return "coerce"
+	case TypeConflictPolicyDiscard:
+		return "discard"
+	default:
+		panic("invalid TypeConflictPolicy")
+	}
+}
+
+var TypeConflictPolicies = map[string]TypeConflictPolicy{
+	TypeConflictPolicyCoerce.String():  TypeConflictPolicyCoerce,
+	TypeConflictPolicyDiscard.String(): TypeConflictPolicyDiscard,
+}
+
+// Row is a single row to be inserted into a Datalayers table.
+type Row struct {
+	// Timestamp is bound to the `ts` column. The current time is used if it is zero.
+	Timestamp time.Time
+	// Tags are the columns used as the partition keys of the table. Their values have the
+	// types of the values of Fields; a nil value is a missing tag.
+	Tags map[string]any
+	// Fields are the value columns of the table. Supported value types are
+	// string, float64, int64, bool and time.Time.
+	Fields map[string]any
+}
+
+// TableBatch groups the rows written to the same table, so that they can be
+// bound to a single prepared INSERT statement.
+type TableBatch struct {
+	DB    string
+	Table string
+	Rows  []Row
+}
+
+// Column describes a value column of a table.
+type Column struct {
+	Name string
+	Type arrow.DataType
+}
+
+// tableKey returns the key used to group rows by their target table.
+func tableKey(db, table string) string {
+	return db + "." + table
+}
+
+// Batches groups rows by their target table, keeping the order in which the tables were first seen.
+type Batches struct {
+	order   []string
+	batches map[string]*TableBatch
+}
+
+func NewBatches() *Batches {
+	return &Batches{batches: map[string]*TableBatch{}}
+}
+
+// Add appends a row to the batch of the given table.
+func (bs *Batches) Add(db, table string, row Row) {
+	key := tableKey(db, table)
+	batch, ok := bs.batches[key]
+	if !ok {
+		batch = &TableBatch{DB: db, Table: table}
+		bs.batches[key] = batch
+		bs.order = append(bs.order, key)
+	}
+	batch.Rows = append(batch.Rows, row)
+}
+
+// List returns the collected batches.
+func (bs *Batches) List() []*TableBatch {
+	list := make([]*TableBatch, 0, len(bs.order))
+	for _, key := range bs.order {
+		list = append(list, bs.batches[key])
+	}
+	return list
+}
+
+// collisionPrefix is prepended to the tags and fields named like another column of the batch.
+const collisionPrefix = "attr_"
+
+// resolveCollisions renames, in all the rows, the tags and fields that would be written to the same
+// column as another one, e.g. a data point attribute named like a value column: the fields named like
+// the `ts` column, and the tags named like the `ts` column or a field of any row, are prefixed with
+// collisionPrefix until their name is unique. Names are compared case-insensitively, as column names are.
+func (b *TableBatch) resolveCollisions() {
+	fields := map[string]struct{}{}
+	tags := map[string]struct{}{}
+	for _, row := range b.Rows {
+		for k := range row.Fields {
+			fields[k] = struct{}{}
+		}
+		for k := range row.Tags {
+			tags[k] = struct{}{}
+		}
+	}
+
+	// fieldNames are the names of the fields, in lower case, the ones named like `ts` excepted.
+	fieldNames := map[string]struct{}{}
+	for k := range fields {
+		if !strings.EqualFold(k, "ts") {
+			fieldNames[strings.ToLower(k)] = struct{}{}
+		}
+	}
+	used := map[string]struct{}{"ts": {}}
+	for k := range fieldNames {
+		used[k] = struct{}{}
+	}
+	for k := range tags {
+		used[strings.ToLower(k)] = struct{}{}
+	}
+	// The names are renamed in order, so that the renamed names do not depend on the order of the maps.
+	rename := func(names map[string]struct{}, reserved func(k string) bool) map[string]string {
+		var colliding []string
+		for k := range names {
+			if reserved(k) {
+				colliding = append(colliding, k)
+			}
+		}
+		sort.Strings(colliding)
+
+		renames := make(map[string]string, len(colliding))
+		for _, k := range colliding {
+			name := collisionPrefix + k
+			for {
+				if _, ok := used[strings.ToLower(name)]; !ok {
+					break
+				}
+				name = collisionPrefix + name
+			}
+			used[strings.ToLower(name)] = struct{}{}
+			renames[k] = name
+		}
+		return renames
+	}
+
+	fieldRenames := rename(fields, func(k string) bool { return strings.EqualFold(k, "ts") })
+	tagRenames := rename(tags, func(k string) bool {
+		_, ok := fieldNames[strings.ToLower(k)]
+		return ok || strings.EqualFold(k, "ts")
+	})
+	for i := range b.Rows {
+		b.Rows[i].Fields = renameKeys(b.Rows[i].Fields, fieldRenames)
+		b.Rows[i].Tags = renameKeys(b.Rows[i].Tags, tagRenames)
+	}
+}
+
+// renameKeys returns the values with their keys renamed, copying the map only if a key is renamed.
+func renameKeys(values map[string]any, renames map[string]string) map[string]any {
+	if len(renames) == 0 {
+		return values
+	}
+	var renamed map[string]any
+	for k, v := range values {
+		name, ok := renames[k]
+		if !ok {
+			continue
+		}
+		if renamed == nil {
+			renamed = make(map[string]any, len(values))
+			for k, v := range values {
+				renamed[k] = v
+			}
+		}
+		delete(renamed, k)
+		renamed[name] = v
+	}
+	if renamed == nil {
+		return values
+	}
+	return renamed
+}
+
+// Columns returns the union of the tag columns and of the value columns over all the rows, both
+// sorted by name, after renaming the colliding ones, see resolveCollisions. The type of a column is
+// taken from the first row that has it, time values using the given unit; tags without any value
+// are strings.
+func (b *TableBatch) Columns(unit arrow.TimeUnit) ([]Column, []Column) {
+	b.resolveCollisions()
+
+	tagTypes := map[string]arrow.DataType{}
+	fieldTypes := map[string]arrow.DataType{}
+	for _, row := range b.Rows {
+		for k, v := range row.Tags {
+			if t := tagTypes[k]; t != nil {
+				continue
+			}
+			tagTypes[k] = arrowType(v, unit)
+		}
+		for k, v := range row.Fields {
+			if _, ok := fieldTypes[k]; ok {
+				continue
+			}
+			if t := arrowType(v, unit); t != nil {
+				fieldTypes[k] = t
+			}
+		}
+	}
+	for k, t := range tagTypes {
+		if t == nil {
+			tagTypes[k] = arrow.BinaryTypes.String
+		}
+	}
+
+	return sortedColumns(tagTypes), sortedColumns(fieldTypes)
+}
+
+// sortedColumns returns the columns sorted by name.
+func sortedColumns(types map[string]arrow.DataType) []Column {
+	columns := make([]Column, 0, len(types))
+	for k, t := range types {
+		columns = append(columns, Column{Name: k, Type: t})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+// InsertSql returns the prepared INSERT statement matching the record built by Record.
+func (b *TableBatch) InsertSql(tags, fields []Column) string {
+	columns := []string{"ts"}
+	for _, tag := range tags {
+		columns = append(columns, addquote(tag.Name))
+	}
+	for _, field := range fields {
+		columns = append(columns, addquote(field.Name))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",")
+
+	return fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)",
+		addquote(b.DB), addquote(b.Table), strings.Join(columns, ","), placeholders)
+}
+
+// Record builds the arrow record binding all the rows of the batch. A missing value is bound as
+// null, or as an empty string for string tags. A value not matching the type of its column is
+// written according to the policy.
+func (b *TableBatch) Record(tags, fields []Column, unit arrow.TimeUnit, policy TypeConflictPolicy) arrow.Record {
+	arrowFields := []arrow.Field{{Name: "ts", Type: timestampType(unit), Nullable: false}}
+	for _, tag := range tags {
+		arrowFields = append(arrowFields, arrow.Field{Name: tag.Name, Type: tag.Type, Nullable: true})
+	}
+	for _, field := range fields {
+		arrowFields = append(arrowFields, arrow.Field{Name: field.Name, Type: field.Type, Nullable: true})
+	}
+	schema := arrow.NewSchema(arrowFields, nil)
+
+	builder := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer builder.Release()
+
+	now := time.Now()
+	for _, row := range b.Rows {
+		ts := row.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		builder.Field(0).(*array.TimestampBuilder).Append(toTimestamp(ts, unit))
+
+		for i, tag := range tags {
+			tagBuilder := builder.Field(1 + i)
+			if v := row.Tags[tag.Name]; v != nil {
+				appendValue(tagBuilder, v, policy)
+			} else if stringBuilder, ok := tagBuilder.(*array.StringBuilder); ok {
+				// A missing string tag is bound as an empty string, the default value of string tag columns.
+				stringBuilder.Append("")
+			} else {
+				tagBuilder.AppendNull()
+			}
+		}
+		for i, field := range fields {
+			appendValue(builder.Field(1+len(tags)+i), row.Fields[field.Name], policy)
+		}
+	}
+
+	return builder.NewRecord()
+}
+
+// arrowType returns the arrow type of a field value, or nil if the value type is not supported.
+func arrowType(v any, unit arrow.TimeUnit) arrow.DataType {
+	switch v.(type) {
+	case time.Time:
+		return timestampType(unit)
+	case string:
+		return arrow.BinaryTypes.String
+	case float64:
+		return arrow.PrimitiveTypes.Float64
+	case int64:
+		return arrow.PrimitiveTypes.Int64
+	case bool:
+		return arrow.FixedWidthTypes.Boolean
+	default:
+		return nil
+	}
+}
+
+// appendValue appends v to the builder. A value not matching the builder's type is converted
+// to it if the policy allows it, and appended as a null otherwise.
+func appendValue(b array.Builder, v any, policy TypeConflictPolicy) {
+	if v == nil {
+		b.AppendNull()
+		return
+	}
+	if policy == TypeConflictPolicyCoerce {
+		v = coerceValue(v, b.Type())
+	}
+
+	switch builder := b.(type) {
+	case *array.StringBuilder:
+		if s, ok := v.(string); ok {
+			builder.Append(s)
+			return
+		}
+	case *array.Float64Builder:
+		if f, ok := v.(float64); ok {
+			builder.Append(f)
+			return
+		}
+	case *array.Int64Builder:
+		if i, ok := v.(int64); ok {
+			builder.Append(i)
+			return
+		}
+	case *array.BooleanBuilder:
+		if bv, ok := v.(bool); ok {
+			builder.Append(bv)
+			return
+		}
+	case *array.TimestampBuilder:
+		if t, ok := v.(time.Time); ok {
+			builder.Append(toTimestamp(t, builder.Type().(*arrow.TimestampType).Unit))
+			return
+		}
+	}
+	b.AppendNull()
+}
+
+// coerceValue converts v to a value of the type t when it can be converted without losing
+// information, and returns v unchanged otherwise.
+func coerceValue(v any, t arrow.DataType) any {
+	switch t.ID() {
+	case arrow.STRING:
+		switch value := v.(type) {
+		case int64:
+			return strconv.FormatInt(value, 10)
+		case float64:
+			return strconv.FormatFloat(value, 'g', -1, 64)
+		case bool:
+			return strconv.FormatBool(value)
+		case time.Time:
+			return value.Format(time.RFC3339Nano)
+		}
+	case arrow.FLOAT64:
+		switch value := v.(type) {
+		case int64:
+			return float64(value)
+		case string:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return f
+			}
+		}
+	case arrow.INT64:
+		switch value := v.(type) {
+		case float64:
+			if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+				return int64(value)
+			}
+		case string:
+			if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return i
+			}
+		}
+	case arrow.BOOL:
+		if value, ok := v.(string); ok {
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b
+			}
+		}
+	}
+	return v
+}
+
+// normalizeType returns the type values are bound with for a column of the type t, or nil if
+// the type is not one of the supported ones: integers are bound as int64, floating point and
+// decimal numbers as float64, strings as strings.
+func normalizeType(t arrow.DataType) arrow.DataType {
+	switch t.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+		arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64:
+		return arrow.PrimitiveTypes.Int64
+	case arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128, arrow.DECIMAL256:
+		return arrow.PrimitiveTypes.Float64
+	case arrow.STRING, arrow.LARGE_STRING, arrow.STRING_VIEW:
+		return arrow.BinaryTypes.String
+	case arrow.BOOL:
+		return arrow.FixedWidthTypes.Boolean
+	case arrow.TIMESTAMP:
+		return timestampType(t.(*arrow.TimestampType).Unit)
+	default:
+		return nil
+	}
+}
+
+// toTimestamp converts t to an arrow timestamp with the given unit.
+// Unlike TimestampBuilder.AppendTime, it does not panic on out of range nanosecond timestamps.
+func toTimestamp(t time.Time, unit arrow.TimeUnit) arrow.Timestamp {
+	switch unit {
+	case arrow.Second:
+		return arrow.Timestamp(t.Unix())
+	case arrow.Microsecond:
+		return arrow.Timestamp(t.UnixMicro())
+	case arrow.Nanosecond:
+		return arrow.Timestamp(t.UnixNano())
+	default:
+		return arrow.Timestamp(t.UnixMilli())
+	}
+}
@@ -0,0 +1,156 @@
+package otel2datalayers
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func TestResolveCollisions(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []Row
+		want []Row
+	}{
+		{
+			name: "no collision",
+			rows: []Row{{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0}}},
+			want: []Row{{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0}}},
+		},
+		{
+			name: "tag named like a field",
+			rows: []Row{{Tags: map[string]any{"value": "a", "le": "b"}, Fields: map[string]any{"value": 1.0, "le": "0.5"}}},
+			want: []Row{{Tags: map[string]any{"attr_value": "a", "attr_le": "b"}, Fields: map[string]any{"value": 1.0, "le": "0.5"}}},
+		},
+		{
+			name: "tag named like the timestamp column",
+			rows: []Row{{Tags: map[string]any{"ts": "a"}, Fields: map[string]any{"value": 1.0}}},
+			want: []Row{{Tags: map[string]any{"attr_ts": "a"}, Fields: map[string]any{"value": 1.0}}},
+		},
+		{
+			name: "tag and field named like the timestamp column",
+			rows: []Row{{Tags: map[string]any{"TS": "a"}, Fields: map[string]any{"ts": int64(1)}}},
+			want: []Row{{Tags: map[string]any{"attr_attr_TS": "a"}, Fields: map[string]any{"attr_ts": int64(1)}}},
+		},
+		{
+			name: "renamed tag named like another tag",
+			rows: []Row{{Tags: map[string]any{"count": "a", "attr_count": "b"}, Fields: map[string]any{"count": int64(1)}}},
+			want: []Row{{Tags: map[string]any{"attr_attr_count": "a", "attr_count": "b"}, Fields: map[string]any{"count": int64(1)}}},
+		},
+		{
+			name: "case-insensitive",
+			rows: []Row{{Tags: map[string]any{"Sum": "a"}, Fields: map[string]any{"sum": 1.0}}},
+			want: []Row{{Tags: map[string]any{"attr_Sum": "a"}, Fields: map[string]any{"sum": 1.0}}},
+		},
+		{
+			name: "tag of a row named like a field of another row",
+			rows: []Row{
+				{Tags: map[string]any{"value_int": "a"}, Fields: map[string]any{"value": 1.0}},
+				{Tags: map[string]any{"host": "b"}, Fields: map[string]any{"value": 2.0, "value_int": int64(2)}},
+			},
+			want: []Row{
+				{Tags: map[string]any{"attr_value_int": "a"}, Fields: map[string]any{"value": 1.0}},
+				{Tags: map[string]any{"host": "b"}, Fields: map[string]any{"value": 2.0, "value_int": int64(2)}},
+			},
+		},
+		{
+			name: "field of a row named like the timestamp column",
+			rows: []Row{
+				{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"ts": "a"}},
+				{Tags: map[string]any{"host": "b"}, Fields: map[string]any{"value": 1.0}},
+			},
+			want: []Row{
+				{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"attr_ts": "a"}},
+				{Tags: map[string]any{"host": "b"}, Fields: map[string]any{"value": 1.0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch := &TableBatch{DB: "db", Table: "table", Rows: tt.rows}
+			batch.resolveCollisions()
+			if !reflect.DeepEqual(batch.Rows, tt.want) {
+				t.Errorf("resolveCollisions() rows = %+v, want %+v", batch.Rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnsWithRowsThatDisagree(t *testing.T) {
+	start := time.Unix(1, 0)
+	// A cumulative and a delta data point of the same table: the start timestamp is a field of the
+	// first one, and an attribute of the second one.
+	batch := &TableBatch{DB: "db", Table: "table", Rows: []Row{
+		{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0, "start_ts": start}},
+		{Tags: map[string]any{"host": "b", "start_ts": "0"}, Fields: map[string]any{"value": 2.0}},
+	}}
+
+	tags, fields := batch.Columns(arrow.Millisecond)
+	if got, want := columnNames(tags), []string{"attr_start_ts", "host"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns() tags = %q, want %q", got, want)
+	}
+	if got, want := columnNames(fields), []string{"start_ts", "value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns() fields = %q, want %q", got, want)
+	}
+	if got := batch.Rows[1].Tags["attr_start_ts"]; got != "0" {
+		t.Errorf("renamed tag = %v, want %q", got, "0")
+	}
+	if got, want := batch.InsertSql(tags, fields), "INSERT INTO `db`.`table` (ts,`attr_start_ts`,`host`,`start_ts`,`value`) VALUES (?,?,?,?,?)"; got != want {
+		t.Errorf("InsertSql() = %q, want %q", got, want)
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := []struct {
+		name string
+		v    any
+		t    arrow.DataType
+		want any
+	}{
+		{name: "int to string", v: int64(-3), t: arrow.BinaryTypes.String, want: "-3"},
+		{name: "float to string", v: 1.5, t: arrow.BinaryTypes.String, want: "1.5"},
+		{name: "bool to string", v: true, t: arrow.BinaryTypes.String, want: "true"},
+		{name: "time to string", v: ts, t: arrow.BinaryTypes.String, want: "2024-01-02T03:04:05.000000006Z"},
+		{name: "int to float", v: int64(3), t: arrow.PrimitiveTypes.Float64, want: 3.0},
+		{name: "numeric string to float", v: "2.5", t: arrow.PrimitiveTypes.Float64, want: 2.5},
+		{name: "string to float", v: "a", t: arrow.PrimitiveTypes.Float64, want: "a"},
+		{name: "bool to float", v: true, t: arrow.PrimitiveTypes.Float64, want: true},
+		{name: "integral float to int", v: 3.0, t: arrow.PrimitiveTypes.Int64, want: int64(3)},
+		{name: "fractional float to int", v: 3.5, t: arrow.PrimitiveTypes.Int64, want: 3.5},
+		{name: "out of range float to int", v: math.MaxFloat64, t: arrow.PrimitiveTypes.Int64, want: math.MaxFloat64},
+		{name: "NaN to int", v: math.NaN(), t: arrow.PrimitiveTypes.Int64, want: "NaN"},
+		{name: "numeric string to int", v: "-7", t: arrow.PrimitiveTypes.Int64, want: int64(-7)},
+		{name: "fractional string to int", v: "7.5", t: arrow.PrimitiveTypes.Int64, want: "7.5"},
+		{name: "string to bool", v: "true", t: arrow.FixedWidthTypes.Boolean, want: true},
+		{name: "invalid string to bool", v: "yes", t: arrow.FixedWidthTypes.Boolean, want: "yes"},
+		{name: "int to bool", v: int64(1), t: arrow.FixedWidthTypes.Boolean, want: int64(1)},
+		{name: "same type", v: int64(1), t: arrow.PrimitiveTypes.Int64, want: int64(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coerceValue(tt.v, tt.t)
+			// NaN is not equal to itself, so it is compared by its string form.
+			if f, ok := got.(float64); ok && math.IsNaN(f) {
+				got = "NaN"
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coerceValue(%v, %v) = %v (%T), want %v (%T)", tt.v, tt.t, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzInsertSql(f *testing.F) {
+	f.Add("db", "table", "host", "value")
+	f.Add("d`b", "t`` able", "`) VALUES (1); DROP TABLE x; --", "v'alue")
+	f.Add("", "`", "``", "'")
+	f.Fuzz(func(t *testing.T, db, table, tag, field string) {
+		batch := &TableBatch{DB: db, Table: table}
+		sql := batch.InsertSql([]Column{{Name: tag}}, []Column{{Name: field}})
+		checkSql(t, sql, "INSERT INTO `?`.`?` (ts,`?`,`?`) VALUES (?,?,?)", []string{db, table, tag, field}, nil)
+	})
+}
@@ -2,128 +2,494 @@ package otel2datalayers
 
 import (
 	"context"
-	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/flight"
 	"github.com/apache/arrow/go/v17/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v17/arrow/memory"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// errClientClosed is returned by the calls made after the client is closed.
+var errClientClosed = errors.New("datalayers client is closed")
+
 type ClientConfig struct {
-	Host     string
-	Port     uint32
+	// Address is the host:port of the Datalayers node.
+	Address string
+	// Username is the user of the basic authentication. The basic authentication is skipped when it
+	// is empty, the requests being authenticated by the per-RPC credentials of the connection.
 	Username string
 	Password string
-	TlsCert  *string
+	// PasswordFile is the file the password is read from, when set. It is read again every time
+	// the client authenticates, so that the password can be rotated.
+	PasswordFile string
+	// Headers are set on every outgoing request.
+	Headers map[string]string
+	// Dial creates the connection to the node at addr. The nodes of the locations of the
+	// results are connected to with it too.
+	Dial func(addr string) (*grpc.ClientConn, error)
 }
 
+// Client executes SQLs on the Datalayers server. It connects lazily, on the first call or Connect,
+// reconnects after transport failures and authenticates again when the server rejects its token.
+// It is safe for concurrent use.
 type Client struct {
-	inner *flightsql.Client
+	config *ClientConfig
+
+	mu     sync.Mutex
+	closed bool
+	// database is set on every outgoing request once not empty, see UseDatabase.
+	database string
+	conn     *connection
 	// Golang uses context to pass Grpc context back and forth.
+	// It is nil until the client is authenticated.
 	ctx context.Context
+	// connecting is closed when the ongoing connection attempt is done. It is nil if there is none.
+	connecting chan struct{}
 }
 
-// Creates a client for executing SQLs on the Datalayers server.
+// connection is a connection to the node and to the nodes of the locations of its results. The
+// fields but inner are guarded by Client.mu.
+type connection struct {
+	inner *flightsql.Client
+	// locations are the clients of the other nodes serving the results of the calls, by address.
+	locations map[string]*flightsql.Client
+	// calls is the number of calls using the connection.
+	calls int
+	// dropped is true once the connection is no longer the one of the client: it is closed when
+	// the last call using it is done.
+	dropped bool
+	closed  bool
+}
+
+// close closes the connection and the connections to the locations, unless they are already closed.
+func (conn *connection) close() error {
+	if conn.closed {
+		return nil
+	}
+	conn.closed = true
+	for _, location := range conn.locations {
+		location.Close()
+	}
+	conn.locations = nil
+	return conn.inner.Close()
+}
+
+// Creates a client for executing SQLs on the Datalayers server, without connecting to it.
+func NewClient(config *ClientConfig) *Client {
+	return &Client{config: config}
+}
+
+// Creates a client for executing SQLs on the Datalayers server and connects to it.
 func MakeClient(config *ClientConfig) (*Client, error) {
-	// Creates a FlightSQL client to connect to Datalayers.
-	// The TLS is enabled if tls_cert is provided, otherwise insecure.
-	addr := fmt.Sprintf("%s:%v", config.Host, config.Port)
-	var dialOpts []grpc.DialOption
-
-	if config.TlsCert != nil && *config.TlsCert != "" {
-		// Load the certificate from the provided path.
-		creds, err := loadTLSCredentials(*config.TlsCert)
+	client := NewClient(config)
+	if err := client.Connect(context.Background()); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Connects and authenticates to the server, unless it is already done.
+func (client *Client) Connect(ctx context.Context) error {
+	conn, _, err := client.session(ctx)
+	if err != nil {
+		return err
+	}
+	client.release(conn)
+	return nil
+}
+
+// session returns the connection and the authenticated context of a call, connecting and
+// authenticating to the server if needed. The connection is used by the call until release.
+// The server is connected to by a single call at a time, without holding client.mu: the other
+// calls wait for it, or for their ctx to be done.
+func (client *Client) session(ctx context.Context) (*connection, context.Context, error) {
+	for {
+		client.mu.Lock()
+		if client.closed {
+			client.mu.Unlock()
+			return nil, nil, errClientClosed
+		}
+		if client.conn != nil && client.ctx != nil {
+			conn, sessionCtx := client.conn, client.ctx
+			conn.calls++
+			client.mu.Unlock()
+			return conn, sessionCtx, nil
+		}
+		if connecting := client.connecting; connecting != nil {
+			client.mu.Unlock()
+			select {
+			case <-connecting:
+				continue
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		connecting := make(chan struct{})
+		client.connecting = connecting
+		conn := client.conn
+		client.mu.Unlock()
+
+		var authCtx context.Context
+		var err error
+		if conn == nil {
+			conn, err = client.dial(client.config.Address)
+		}
+		if err == nil {
+			authCtx, err = client.authenticate(ctx, conn.inner)
+		}
+
+		client.mu.Lock()
+		client.connecting = nil
+		close(connecting)
+		switch {
+		case client.closed:
+			if conn != nil {
+				conn.close()
+			}
+			client.mu.Unlock()
+			return nil, nil, errClientClosed
+		case conn == nil:
+		case conn.dropped:
+			// Another call dropped the connection while it was authenticated with.
+			if err == nil {
+				client.mu.Unlock()
+				continue
+			}
+		default:
+			// The connection is kept even if the authentication failed, the next attempt
+			// authenticating again with it.
+			client.conn = conn
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+			client.mu.Unlock()
+			return nil, nil, err
+		}
+		if client.database != "" {
+			authCtx = metadata.AppendToOutgoingContext(authCtx, "database", client.database)
 		}
+		client.ctx = authCtx
+		conn.calls++
+		client.mu.Unlock()
+		return conn, authCtx, nil
+	}
+}
 
-		// Use secure credentials with the loaded certificate.
-		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
-	} else {
-		// Use insecure credentials if no TLS certificate is provided.
-		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// authenticate authenticates with the server with inner, if the client has a username, and returns
+// the context carrying the token and the headers of the calls.
+func (client *Client) authenticate(ctx context.Context, inner *flightsql.Client) (context.Context, error) {
+	authCtx := context.Background()
+	if client.config.Username != "" {
+		// Authenticates with the server.
+		password, err := client.config.password()
+		if err != nil {
+			return nil, err
+		}
+		authCtx, err = inner.Client.AuthenticateBasicToken(ctx, client.config.Username, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate with the server: %w", err)
+		}
+		// The token is kept in the outgoing metadata, independently of the lifetime of ctx.
+		md, _ := metadata.FromOutgoingContext(authCtx)
+		authCtx = metadata.NewOutgoingContext(context.Background(), md)
 	}
+	for k, v := range client.config.Headers {
+		authCtx = metadata.AppendToOutgoingContext(authCtx, k, v)
+	}
+	return authCtx, nil
+}
 
-	// Creates a FlightSQL client to connect to Datalayers.
-	flightSqlClient, err := flightsql.NewClient(addr, nil, nil, dialOpts...)
+// release ends the use of the connection by a call, see session.
+func (client *Client) release(conn *connection) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	conn.calls--
+	if conn.dropped && conn.calls == 0 {
+		conn.close()
+	}
+}
+
+// password returns the password of the basic authentication.
+func (config *ClientConfig) password() (string, error) {
+	if config.PasswordFile == "" {
+		return config.Password, nil
+	}
+	password, err := os.ReadFile(config.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the password file: %w", err)
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
+
+// dial creates a connection to the Datalayers node at addr.
+func (client *Client) dial(addr string) (*connection, error) {
+	inner, err := dial(addr, client.config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a Arrow Flight SQL client: %v", err)
+		return nil, err
 	}
+	return &connection{inner: inner}, nil
+}
 
-	// Authenticates with the server.
-	ctx, err := flightSqlClient.Client.AuthenticateBasicToken(context.Background(), config.Username, config.Password)
+// Creates a FlightSQL client to connect to the Datalayers node at addr.
+func dial(addr string, config *ClientConfig) (*flightsql.Client, error) {
+	conn, err := config.Dial(addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to authenticate with the server: %v", err)
+		return nil, fmt.Errorf("failed to create a Arrow Flight SQL client: %w", err)
 	}
+	return &flightsql.Client{
+		Client: flight.NewClientFromConn(conn, nil),
+		Alloc:  memory.DefaultAllocator,
+	}, nil
+}
 
-	client := &Client{
-		inner: flightSqlClient,
-		ctx:   ctx,
+// call runs fn with the connection of the session, the context given to fn carrying the
+// authentication of the session and being done when ctx is. If the server rejects the token, the
+// client authenticates again and fn is retried once. If the server is unavailable, the connection
+// is dropped so that the next call reconnects; the error is returned, the caller being the one to
+// know whether fn can be retried.
+func (client *Client) call(ctx context.Context, fn func(conn *connection, ctx context.Context) error) error {
+	err := client.callOnce(ctx, fn)
+	if status.Code(err) == codes.Unauthenticated {
+		err = client.callOnce(ctx, fn)
 	}
-	return client, nil
+	return err
 }
 
-// Helper function to load TLS credentials from a certificate file.
-func loadTLSCredentials(tlsCert string) (credentials.TransportCredentials, error) {
-	// Reads the certificate file.
-	cert, err := os.ReadFile(tlsCert)
+// callOnce runs fn with the connection of the session, and drops the authentication or the
+// connection of the session if the call fails because of them.
+func (client *Client) callOnce(ctx context.Context, fn func(conn *connection, ctx context.Context) error) error {
+	conn, sessionCtx, err := client.session(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not read TLS certificate: %v", err)
+		return err
+	}
+	defer client.release(conn)
+
+	err = fn(conn, callContext(ctx, sessionCtx))
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		client.reset(conn, false)
+	case codes.Unavailable:
+		client.reset(conn, true)
 	}
+	return err
+}
+
+// callContext returns ctx with the outgoing metadata of the session, i.e. its token and headers.
+func callContext(ctx, sessionCtx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(sessionCtx)
+	return metadata.NewOutgoingContext(ctx, md)
+}
 
-	// Creates certificate pool and append cert.
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(cert) {
-		return nil, fmt.Errorf("failed to append cert to pool")
+// reset drops the authentication of the session of conn and, if reconnect is true, the connection,
+// which is closed once the calls using it are done. It does nothing if the session was already
+// replaced by another call.
+func (client *Client) reset(conn *connection, reconnect bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.conn != conn {
+		return
 	}
+	client.ctx = nil
+	if reconnect {
+		client.conn = nil
+		conn.dropped = true
+		if conn.calls == 0 {
+			conn.close()
+		}
+	}
+}
 
-	// Creates TLS credentials based on the certificate pool.
-	creds := credentials.NewClientTLSFromCert(certPool, "")
-	return creds, nil
+// Returns the address of the node the client connects to.
+func (client *Client) Address() string {
+	return client.config.Address
 }
 
 // Sets the database context for each outgoing request.
 func (client *Client) UseDatabase(database string) {
-	client.ctx = metadata.AppendToOutgoingContext(client.ctx, "database", database)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.database = database
+	if client.ctx != nil {
+		client.ctx = metadata.AppendToOutgoingContext(client.ctx, "database", database)
+	}
 }
 
 // Executes the sql on Datalayers and returns the result as a slice of arrow records.
-func (client *Client) Execute(sql string) ([]arrow.Record, error) {
-	flightInfo, err := client.inner.Execute(client.ctx, sql)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute a sql: %v", err)
-	}
-	return client.doGet(flightInfo.GetEndpoint()[0].GetTicket())
+func (client *Client) Execute(ctx context.Context, sql string) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := client.call(ctx, func(conn *connection, ctx context.Context) error {
+		flightInfo, err := conn.inner.Execute(ctx, sql)
+		if err != nil {
+			return fmt.Errorf("failed to execute a sql: %w", err)
+		}
+		records, err = client.doGet(ctx, conn, flightInfo)
+		return err
+	})
+	return records, err
+}
+
+// Lists the catalogs of the server.
+func (client *Client) GetCatalogs(ctx context.Context) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := client.call(ctx, func(conn *connection, ctx context.Context) error {
+		flightInfo, err := conn.inner.GetCatalogs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get catalogs: %w", err)
+		}
+		records, err = client.doGet(ctx, conn, flightInfo)
+		return err
+	})
+	return records, err
+}
+
+// Lists the tables of the server matching the options.
+func (client *Client) GetTables(ctx context.Context, opts *flightsql.GetTablesOpts) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := client.call(ctx, func(conn *connection, ctx context.Context) error {
+		flightInfo, err := conn.inner.GetTables(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to get tables: %w", err)
+		}
+		records, err = client.doGet(ctx, conn, flightInfo)
+		return err
+	})
+	return records, err
 }
 
 // Creates a prepared statement.
-func (client *Client) Prepare(sql string) (*flightsql.PreparedStatement, error) {
-	return client.inner.Prepare(client.ctx, sql)
+func (client *Client) Prepare(ctx context.Context, sql string) (*flightsql.PreparedStatement, error) {
+	var preparedStmt *flightsql.PreparedStatement
+	err := client.call(ctx, func(conn *connection, ctx context.Context) error {
+		var err error
+		preparedStmt, err = conn.inner.Prepare(ctx, sql)
+		return err
+	})
+	return preparedStmt, err
 }
 
 // Binds the record to the prepared statement and executes it on the server.
-func (client *Client) ExecutePrepared(preparedStmt *flightsql.PreparedStatement, binding arrow.Record) ([]arrow.Record, error) {
+func (client *Client) ExecutePrepared(ctx context.Context, preparedStmt *flightsql.PreparedStatement, binding arrow.Record) ([]arrow.Record, error) {
 	defer binding.Release()
 
 	preparedStmt.SetParameters(binding)
-	flightInfo, err := preparedStmt.Execute(client.ctx)
+	var records []arrow.Record
+	err := client.call(ctx, func(conn *connection, ctx context.Context) error {
+		flightInfo, err := preparedStmt.Execute(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to execute a prepared statement: %w", err)
+		}
+		records, err = client.doGet(ctx, conn, flightInfo)
+		return err
+	})
+	return records, err
+}
+
+// Closes the prepared statement on the server.
+func (client *Client) ClosePrepared(ctx context.Context, preparedStmt *flightsql.PreparedStatement) error {
+	return client.call(ctx, func(_ *connection, ctx context.Context) error {
+		return preparedStmt.Close(ctx)
+	})
+}
+
+// Closes the connection to the server, failing the calls using it. The calls made afterwards fail.
+func (client *Client) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.closed = true
+	client.ctx = nil
+	if client.conn == nil {
+		return nil
+	}
+	client.conn.dropped = true
+	err := client.conn.close()
+	client.conn = nil
+	return err
+}
+
+// location returns the client of the node at the location. The node the client is connected to
+// is served by conn.
+func (client *Client) location(conn *connection, location *flight.Location) (*flightsql.Client, error) {
+	if location.GetUri() == flight.LocationReuseConnection {
+		return conn.inner, nil
+	}
+	u, err := url.Parse(location.GetUri())
+	if err != nil {
+		return nil, fmt.Errorf("invalid location %q: %w", location.GetUri(), err)
+	}
+	if u.Host == "" || u.Host == client.config.Address {
+		return conn.inner, nil
+	}
+
+	client.mu.Lock()
+	locationClient, ok := conn.locations[u.Host]
+	client.mu.Unlock()
+	if ok {
+		return locationClient, nil
+	}
+	// The other nodes of the cluster are connected to with the same credentials.
+	locationClient, err = dial(u.Host, client.config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute a prepared statement: %v", err)
+		return nil, err
 	}
-	return client.doGet(flightInfo.GetEndpoint()[0].GetTicket())
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if conn.closed {
+		locationClient.Close()
+		return nil, errClientClosed
+	}
+	if existing, ok := conn.locations[u.Host]; ok {
+		// Another call connected to the node in the meantime.
+		locationClient.Close()
+		return existing, nil
+	}
+	if conn.locations == nil {
+		conn.locations = map[string]*flightsql.Client{}
+	}
+	conn.locations[u.Host] = locationClient
+	return locationClient, nil
+}
+
+// Reads the results of the first endpoint of the FlightInfo. The ticket is redeemed at the
+// locations of the endpoint, tried in order, or at the connected node if there are none.
+func (client *Client) doGet(ctx context.Context, conn *connection, flightInfo *flight.FlightInfo) ([]arrow.Record, error) {
+	if len(flightInfo.GetEndpoint()) == 0 {
+		return nil, errors.New("no endpoint in the FlightInfo")
+	}
+	endpoint := flightInfo.GetEndpoint()[0]
+	if len(endpoint.GetLocation()) == 0 {
+		return doGet(ctx, conn.inner, endpoint.GetTicket())
+	}
+
+	var errs []error
+	for _, location := range endpoint.GetLocation() {
+		locationClient, err := client.location(conn, location)
+		if err == nil {
+			var records []arrow.Record
+			if records, err = doGet(ctx, locationClient, endpoint.GetTicket()); err == nil {
+				return records, nil
+			}
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
 }
 
 // Calls the `DoGet` method of the FlightSQL client.
-func (client *Client) doGet(ticket *flight.Ticket) ([]arrow.Record, error) {
-	reader, err := client.inner.DoGet(client.ctx, ticket)
+func doGet(ctx context.Context, inner *flightsql.Client, ticket *flight.Ticket) ([]arrow.Record, error) {
+	reader, err := inner.DoGet(ctx, ticket)
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform DoGet: %v", err)
+		return nil, fmt.Errorf("failed to perform DoGet: %w", err)
 	}
 	defer reader.Release()
 
@@ -134,5 +500,9 @@ func (client *Client) doGet(ticket *flight.Ticket) ([]arrow.Record, error) {
 		record.Retain()
 		records = append(records, record)
 	}
+	if err := reader.Err(); err != nil {
+		releaseRecords(records)
+		return nil, fmt.Errorf("failed to read DoGet stream: %w", err)
+	}
 	return records, nil
 }
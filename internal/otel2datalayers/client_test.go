@@ -0,0 +1,281 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeNode counts the connections to a node and the authentications with it, without any server:
+// the connections are never connected, the handshakes being answered by an interceptor.
+type fakeNode struct {
+	mu         sync.Mutex
+	dials      int
+	handshakes int
+	// dialing, if not nil, receives the dials, which then wait for release to be closed.
+	dialing chan struct{}
+	release chan struct{}
+}
+
+func (n *fakeNode) dial(addr string) (*grpc.ClientConn, error) {
+	if n.dialing != nil {
+		n.dialing <- struct{}{}
+		<-n.release
+	}
+	n.mu.Lock()
+	n.dials++
+	n.mu.Unlock()
+	return grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(n.handshake))
+}
+
+// handshake answers the handshakes with a new token every time.
+func (n *fakeNode) handshake(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, grpc.Streamer, ...grpc.CallOption) (grpc.ClientStream, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handshakes++
+	return &handshakeStream{token: fmt.Sprintf("Bearer token-%d", n.handshakes)}, nil
+}
+
+func (n *fakeNode) counts() (dials, handshakes int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dials, n.handshakes
+}
+
+// handshakeStream is a handshake returning the token in its headers.
+type handshakeStream struct {
+	grpc.ClientStream
+	token string
+}
+
+func (s *handshakeStream) Header() (metadata.MD, error) {
+	return metadata.Pairs("authorization", s.token), nil
+}
+func (s *handshakeStream) Trailer() metadata.MD { return nil }
+func (s *handshakeStream) CloseSend() error     { return nil }
+func (s *handshakeStream) RecvMsg(any) error    { return io.EOF }
+
+func newFakeClient(node *fakeNode) *Client {
+	return NewClient(&ClientConfig{Address: "localhost:8360", Username: "admin", Password: "public", Dial: node.dial})
+}
+
+// token returns the token of the outgoing metadata of ctx.
+func token(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if tokens := md.Get("authorization"); len(tokens) > 0 {
+		return tokens[0]
+	}
+	return ""
+}
+
+func TestClientCall(t *testing.T) {
+	tests := []struct {
+		name string
+		// errs are returned by the successive calls of the function.
+		errs     []error
+		wantCode codes.Code
+		// wantTokens are the tokens of the calls of the function, then of a following call.
+		wantTokens []string
+		wantDials  int
+	}{
+		{
+			name:       "success",
+			errs:       []error{nil},
+			wantTokens: []string{"Bearer token-1", "Bearer token-1"},
+			wantDials:  1,
+		},
+		{
+			name:       "token rejected and retried",
+			errs:       []error{status.Error(codes.Unauthenticated, "token expired"), nil},
+			wantTokens: []string{"Bearer token-1", "Bearer token-2", "Bearer token-2"},
+			wantDials:  1,
+		},
+		{
+			name:       "new token rejected",
+			errs:       []error{status.Error(codes.Unauthenticated, "token expired"), status.Error(codes.Unauthenticated, "token expired")},
+			wantCode:   codes.Unauthenticated,
+			wantTokens: []string{"Bearer token-1", "Bearer token-2", "Bearer token-3"},
+			wantDials:  1,
+		},
+		{
+			name:       "unavailable",
+			errs:       []error{status.Error(codes.Unavailable, "connection refused")},
+			wantCode:   codes.Unavailable,
+			wantTokens: []string{"Bearer token-1", "Bearer token-2"},
+			wantDials:  2,
+		},
+		{
+			name:       "other error",
+			errs:       []error{status.Error(codes.Internal, "failed")},
+			wantCode:   codes.Internal,
+			wantTokens: []string{"Bearer token-1", "Bearer token-1"},
+			wantDials:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &fakeNode{}
+			client := newFakeClient(node)
+			defer client.Close()
+
+			var tokens []string
+			errs := tt.errs
+			err := client.call(context.Background(), func(_ *connection, ctx context.Context) error {
+				tokens = append(tokens, token(ctx))
+				err := errs[0]
+				errs = errs[1:]
+				return err
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("call() = %v, want code %s", err, tt.wantCode)
+			}
+			if len(errs) != 0 {
+				t.Errorf("call() made %d calls, want %d", len(tt.errs)-len(errs), len(tt.errs))
+			}
+
+			err = client.call(context.Background(), func(_ *connection, ctx context.Context) error {
+				tokens = append(tokens, token(ctx))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("following call() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(tokens, tt.wantTokens) {
+				t.Errorf("call() tokens = %q, want %q", tokens, tt.wantTokens)
+			}
+			if dials, _ := node.counts(); dials != tt.wantDials {
+				t.Errorf("%d dials, want %d", dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func TestClientResetKeepsConnectionInUse(t *testing.T) {
+	client := newFakeClient(&fakeNode{})
+	defer client.Close()
+
+	inUse, _, err := client.session(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.call(context.Background(), func(conn *connection, _ context.Context) error {
+		if conn != inUse {
+			t.Error("call() did not use the connection of the session")
+		}
+		return status.Error(codes.Unavailable, "connection refused")
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("call() = %v, want code %s", err, codes.Unavailable)
+	}
+
+	client.mu.Lock()
+	closed := inUse.closed
+	client.mu.Unlock()
+	if closed {
+		t.Error("reset closed a connection in use")
+	}
+	client.release(inUse)
+	client.mu.Lock()
+	closed = inUse.closed
+	client.mu.Unlock()
+	if !closed {
+		t.Error("release did not close the dropped connection")
+	}
+}
+
+func TestClientSlowDial(t *testing.T) {
+	node := &fakeNode{dialing: make(chan struct{}), release: make(chan struct{})}
+	client := newFakeClient(node)
+	defer client.Close()
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect(context.Background()) }()
+	<-node.dialing
+
+	// The client is not locked while dialing: the other calls wait for the dial, or for their ctx.
+	client.UseDatabase("db")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := client.call(ctx, func(*connection, context.Context) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call() while dialing = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(node.release)
+	if err := <-connected; err != nil {
+		t.Errorf("Connect() = %v, want nil", err)
+	}
+	err = client.call(context.Background(), func(_ *connection, ctx context.Context) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if got := md.Get("database"); !reflect.DeepEqual(got, []string{"db"}) {
+			t.Errorf("call() database = %q, want %q", got, []string{"db"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("call() = %v, want nil", err)
+	}
+	if dials, handshakes := node.counts(); dials != 1 || handshakes != 1 {
+		t.Errorf("%d dials and %d handshakes, want 1 and 1", dials, handshakes)
+	}
+}
+
+func TestClientCloseWhileDialing(t *testing.T) {
+	node := &fakeNode{dialing: make(chan struct{}), release: make(chan struct{})}
+	client := newFakeClient(node)
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect(context.Background()) }()
+	<-node.dialing
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() = %v, want nil", err)
+	}
+	close(node.release)
+	if err := <-connected; !errors.Is(err, errClientClosed) {
+		t.Errorf("Connect() = %v, want %v", err, errClientClosed)
+	}
+	if err := client.Connect(context.Background()); !errors.Is(err, errClientClosed) {
+		t.Errorf("Connect() after Close = %v, want %v", err, errClientClosed)
+	}
+}
+
+func TestClientConfigPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	config := &ClientConfig{Password: "ignored", PasswordFile: file}
+	for _, password := range []string{"public", "rotated"} {
+		// The trailing newline of the file is not part of the password.
+		if err := os.WriteFile(file, []byte(password+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := config.password(); err != nil || got != password {
+			t.Errorf("password() = %q, %v, want %q", got, err, password)
+		}
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.password(); err == nil {
+		t.Error("password() without the file = nil, want an error")
+	}
+
+	config = &ClientConfig{Password: "public"}
+	if got, err := config.password(); err != nil || got != "public" {
+		t.Errorf("password() = %q, %v, want %q", got, err, "public")
+	}
+}
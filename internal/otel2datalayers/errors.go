@@ -0,0 +1,69 @@
+package otel2datalayers
+
+import (
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errEmptyPartitionKeys is returned for rows without any tag, which cannot be written.
+var errEmptyPartitionKeys = consumererror.NewPermanent(errors.New("PartitionKeys is empty"))
+
+// errStaleSchema marks the errors caused by a cached table that was dropped or altered on the server.
+// They are retryable: the table is evicted from the schema cache, so that the next attempt checks it again.
+var errStaleSchema = errors.New("table changed on the server since it was cached")
+
+// syntaxErrorMessages are the messages of the errors Datalayers returns for statements it cannot parse or plan.
+var syntaxErrorMessages = []string{
+	"syntax error",
+	"sql parser error",
+	"parsererror",
+	"schema error",
+}
+
+// classifyError marks the errors that cannot be fixed by retrying as permanent, so that the
+// exporter helper drops the data instead of retrying it. Errors without a gRPC status, such as
+// transport errors, are left retryable.
+func classifyError(err error) error {
+	if err == nil || consumererror.IsPermanent(err) || errors.Is(err, errStaleSchema) {
+		return err
+	}
+
+	lower := strings.ToLower(err.Error())
+	for _, msg := range syntaxErrorMessages {
+		if strings.Contains(lower, msg) {
+			return consumererror.NewPermanent(err)
+		}
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.InvalidArgument,
+		codes.AlreadyExists,
+		codes.NotFound,
+		codes.FailedPrecondition,
+		codes.OutOfRange,
+		codes.PermissionDenied,
+		codes.Unimplemented:
+		return consumererror.NewPermanent(err)
+	default:
+		// Unavailable, ResourceExhausted, DeadlineExceeded, Aborted, Unauthenticated, ...
+		return err
+	}
+}
+
+// isSchemaError reports whether err is returned for a database, table or column that does not exist,
+// or does not have the expected type.
+func isSchemaError(err error) bool {
+	if strings.Contains(strings.ToLower(err.Error()), "schema error") {
+		return true
+	}
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.NotFound
+}
@@ -0,0 +1,188 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantPermanent bool
+	}{
+		{name: "permanent", err: consumererror.NewPermanent(errors.New("failed")), wantPermanent: true},
+		{name: "syntax error", err: status.Error(codes.Internal, "SQL parser error: Expected identifier"), wantPermanent: true},
+		{name: "wrapped schema error", err: fmt.Errorf("failed to insert: %w", status.Error(codes.Unknown, "Schema error: no field named x")), wantPermanent: true},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "invalid"), wantPermanent: true},
+		{name: "not found", err: status.Error(codes.NotFound, "no such table"), wantPermanent: true},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "denied"), wantPermanent: true},
+		{name: "unimplemented", err: status.Error(codes.Unimplemented, "unimplemented"), wantPermanent: true},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused")},
+		{name: "resource exhausted", err: status.Error(codes.ResourceExhausted, "too many requests")},
+		{name: "deadline exceeded", err: status.Error(codes.DeadlineExceeded, "deadline exceeded")},
+		{name: "unauthenticated", err: status.Error(codes.Unauthenticated, "token expired")},
+		{name: "context deadline", err: context.DeadlineExceeded},
+		{name: "transport error", err: errors.New("connection reset by peer")},
+		{name: "stale schema", err: fmt.Errorf("%w: %w", errStaleSchema, status.Error(codes.NotFound, "no such table"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("classifyError() = %v, does not wrap %v", got, tt.err)
+			}
+			if permanent := consumererror.IsPermanent(got); permanent != tt.wantPermanent {
+				t.Errorf("classifyError() permanent = %v, want %v", permanent, tt.wantPermanent)
+			}
+		})
+	}
+
+	if err := classifyError(nil); err != nil {
+		t.Errorf("classifyError(nil) = %v, want nil", err)
+	}
+}
+
+func newTestWriter(t *testing.T, config *DatalayerWritterConfig) *DatalayerWritter {
+	t.Helper()
+	if config.TimestampPrecision == "" {
+		config.TimestampPrecision = "ms"
+	}
+	if len(config.Endpoints) == 0 {
+		config.Endpoints = []string{"localhost:8360"}
+	}
+	w, err := NewDatalayerWritter(config, component.TelemetrySettings{
+		Logger:        zap.NewNop(),
+		MeterProvider: noop.NewMeterProvider(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// newUnavailableClient returns a client whose connections fail as if the server was down.
+func TestEvictStaleTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		cached    bool
+		err       error
+		wantEvict bool
+	}{
+		{name: "no error", cached: true},
+		{name: "table not found", cached: true, err: status.Error(codes.NotFound, "table db.table not found"), wantEvict: true},
+		{name: "schema error", cached: true, err: fmt.Errorf("failed to insert: %w", status.Error(codes.Internal, "Schema error: no field named x")), wantEvict: true},
+		{name: "unavailable", cached: true, err: status.Error(codes.Unavailable, "connection refused")},
+		// The table was just checked, retrying would not fix it.
+		{name: "table not cached", err: status.Error(codes.NotFound, "table db.table not found")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(t, &DatalayerWritterConfig{})
+			if tt.cached {
+				w.schema.setTable("db", "table", map[string]arrow.DataType{"ts": arrow.FixedWidthTypes.Timestamp_ms})
+			}
+			w.schema.setTable("other", "table", map[string]arrow.DataType{"ts": arrow.FixedWidthTypes.Timestamp_ms})
+
+			err := w.evictStaleTable("db", "table", tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("evictStaleTable() = %v, does not wrap %v", err, tt.err)
+			}
+			if evicted := errors.Is(err, errStaleSchema); evicted != tt.wantEvict {
+				t.Errorf("evictStaleTable() evicted = %v, want %v", evicted, tt.wantEvict)
+			}
+			if tt.wantEvict {
+				if consumererror.IsPermanent(classifyError(err)) {
+					t.Errorf("classifyError(%v) is permanent, want retryable", err)
+				}
+				if w.schema.hasDatabase("db") || w.schema.hasTable("db", "table") {
+					t.Error("evictStaleTable() kept the database in the cache")
+				}
+			} else if tt.cached && !w.schema.hasTable("db", "table") {
+				t.Error("evictStaleTable() evicted the table from the cache")
+			}
+			if !w.schema.hasTable("other", "table") {
+				t.Error("evictStaleTable() evicted another database from the cache")
+			}
+		})
+	}
+}
+
+func newUnavailableClient(t *testing.T) *ClientPool {
+	t.Helper()
+	dial := func(string) (*grpc.ClientConn, error) {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	}
+	client, err := NewClientPool([]*ClientConfig{{Address: "localhost:8360", Dial: dial}}, LoadBalancingRoundRobin, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestWriteBatchesPermanentError(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 2})
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	// A batch without any tag cannot be written, whatever the server.
+	batches := []*TableBatch{{DB: "db", Table: "table", Rows: []Row{{Fields: map[string]any{"value": 1.0}}}}}
+	retry, err := w.WriteBatches(context.Background(), batches)
+	if len(retry) != 0 {
+		t.Errorf("WriteBatches() retries %d batches, want none", len(retry))
+	}
+	if !consumererror.IsPermanent(err) || !errors.Is(err, errEmptyPartitionKeys) {
+		t.Errorf("WriteBatches() = %v, want a permanent %v", err, errEmptyPartitionKeys)
+	}
+}
+
+func TestWriteBatchesDoneContext(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 1})
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	batches := []*TableBatch{{DB: "db", Table: "table", Rows: []Row{{Tags: map[string]any{"host": "a"}}}}}
+	retry, err := w.WriteBatches(ctx, batches)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteBatches() = %v, want %v", err, context.Canceled)
+	}
+	if !reflect.DeepEqual(retry, batches) {
+		t.Errorf("WriteBatches() retries %v, want %v", retry, batches)
+	}
+}
+
+func TestWriteBatchesMixedErrors(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 2})
+	// The server is down: the batches reaching it fail with a retryable error.
+	w.client = newUnavailableClient(t)
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	written := &TableBatch{DB: "db", Table: "empty"}
+	permanent := &TableBatch{DB: "db", Table: "no_tags", Rows: []Row{{Fields: map[string]any{"value": 1.0}}}}
+	retryable := &TableBatch{DB: "db", Table: "tagged", Rows: []Row{{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0}}}}
+	retry, err := w.WriteBatches(context.Background(), []*TableBatch{written, permanent, retryable})
+	if !reflect.DeepEqual(retry, []*TableBatch{retryable}) {
+		t.Errorf("WriteBatches() retries %v, want %v", retry, []*TableBatch{retryable})
+	}
+	if err == nil || consumererror.IsPermanent(err) {
+		t.Errorf("WriteBatches() = %v, want a retryable error", err)
+	}
+	if errors.Is(err, errEmptyPartitionKeys) {
+		t.Errorf("WriteBatches() = %v, want only the errors of the batches to retry", err)
+	}
+}
@@ -0,0 +1,276 @@
+package otel2datalayers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"go.uber.org/zap"
+)
+
+// OverflowPolicy defines how the values of the columns exceeding the column limit of their table are
+// written. The lines of the series exceeding the series limit are always dropped: written without
+// their tags, the lines of a timestamp would overwrite each other.
+type OverflowPolicy uint8
+
+const (
+	// OverflowPolicyFold folds the values of the columns that cannot be added into the extra
+	// attributes column, a JSON object.
+	OverflowPolicyFold OverflowPolicy = iota
+	// OverflowPolicyDrop drops the values of the columns that cannot be added.
+	OverflowPolicyDrop
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowPolicyFold:
+		return "fold"
+	case OverflowPolicyDrop:
+		return "drop"
+	default:
+		panic("invalid OverflowPolicy")
+	}
+}
+
+var OverflowPolicies = map[string]OverflowPolicy{
+	OverflowPolicyFold.String(): OverflowPolicyFold,
+	OverflowPolicyDrop.String(): OverflowPolicyDrop,
+}
+
+// extraAttributesColumn is the column the values over the limits are folded into.
+// It does not count towards the column limit.
+const extraAttributesColumn = "extra_attributes"
+
+// seriesLimiter tracks the distinct series, i.e. combinations of tag values, written to each table
+// during an interval. It is safe for concurrent use.
+type seriesLimiter struct {
+	max      int
+	interval time.Duration
+
+	mu     sync.Mutex
+	tables map[string]*tableSeries
+}
+
+// tableSeries are the series written to a table since the start of its current interval.
+type tableSeries struct {
+	since  time.Time
+	series map[uint64]struct{}
+}
+
+func newSeriesLimiter(max int, interval time.Duration) *seriesLimiter {
+	return &seriesLimiter{
+		max:      max,
+		interval: interval,
+		tables:   map[string]*tableSeries{},
+	}
+}
+
+// admit returns true if the series can be written to the table: it was already written during the
+// current interval of the table, or the table has fewer series than the limit.
+func (l *seriesLimiter) admit(key string, series uint64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	table, ok := l.tables[key]
+	if !ok || now.Sub(table.since) >= l.interval {
+		table = &tableSeries{since: now, series: map[uint64]struct{}{}}
+		l.tables[key] = table
+	}
+	if _, ok := table.series[series]; ok {
+		return true
+	}
+	if len(table.series) >= l.max {
+		return false
+	}
+	table.series[series] = struct{}{}
+	return true
+}
+
+// seriesID returns the hash identifying the series of the tags, the missing tags being ignored.
+func seriesID(tags map[string]any) uint64 {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%T\x00%v\x00", k, tags[k], tags[k])
+	}
+	return h.Sum64()
+}
+
+// limitBatch truncates the tag values longer than the limit, and drops the lines of the series over
+// the limit of the table, whatever the overflow policy.
+func (w *DatalayerWritter) limitBatch(batch *TableBatch) {
+	fields := []zap.Field{zap.String("database", batch.DB), zap.String("table", batch.Table)}
+
+	if w.maxAttributeValueLength > 0 {
+		truncated := 0
+		for _, row := range batch.Rows {
+			if truncateValues(row.Tags, w.maxAttributeValueLength) {
+				truncated++
+			}
+		}
+		w.telemetry.recordLimited(context.Background(), truncated, limitAttributeValueLength, limitActionTruncated, fields...)
+	}
+
+	if w.series == nil {
+		return
+	}
+	key := tableKey(batch.DB, batch.Table)
+	now := time.Now()
+	rows := batch.Rows[:0]
+	for _, row := range batch.Rows {
+		if w.series.admit(key, seriesID(row.Tags), now) {
+			rows = append(rows, row)
+		}
+	}
+	dropped := len(batch.Rows) - len(rows)
+	batch.Rows = rows
+
+	w.telemetry.recordLimited(context.Background(), dropped, limitSeriesPerTable, limitActionDropped, fields...)
+	w.telemetry.recordDropped(context.Background(), dropped, dropReasonSeriesLimit, fields...)
+}
+
+// limitColumns splits the columns into the ones the table has or can have within the column limit,
+// and the ones over it. The value columns are added before the tag columns, as the attribute keys
+// used as tags are the ones that grow without bound.
+func (w *DatalayerWritter) limitColumns(db, table string, partitions, fields []Column) ([]Column, []Column, []Column) {
+	if w.maxColumns <= 0 {
+		return partitions, fields, nil
+	}
+
+	missing := map[string]struct{}{}
+	for _, name := range w.schema.missingColumns(db, table, columnNames(partitions, fields)) {
+		missing[name] = struct{}{}
+	}
+	capacity := w.maxColumns - w.schema.columnCount(db, table)
+	var overflow []Column
+	admit := func(columns []Column) []Column {
+		kept := make([]Column, 0, len(columns))
+		for _, column := range columns {
+			if _, ok := missing[column.Name]; ok && column.Name != extraAttributesColumn {
+				if capacity <= 0 {
+					overflow = append(overflow, column)
+					continue
+				}
+				capacity--
+			}
+			kept = append(kept, column)
+		}
+		return kept
+	}
+	fields = admit(fields)
+	partitions = admit(partitions)
+	return partitions, fields, overflow
+}
+
+// foldOverflow removes the columns over the column limit from the tags and fields, folding their
+// values into the extra attributes column or dropping them.
+func (w *DatalayerWritter) foldOverflow(batch *TableBatch, tags, fields, overflow []Column) ([]Column, []Column) {
+	overflowed := make(map[string]struct{}, len(overflow))
+	for _, column := range overflow {
+		overflowed[column.Name] = struct{}{}
+	}
+	without := func(columns []Column) []Column {
+		kept := make([]Column, 0, len(columns))
+		for _, column := range columns {
+			if _, ok := overflowed[column.Name]; !ok {
+				kept = append(kept, column)
+			}
+		}
+		return kept
+	}
+	tags, fields = without(tags), without(fields)
+
+	limited := 0
+	for i := range batch.Rows {
+		row := &batch.Rows[i]
+		values := map[string]any{}
+		for name := range overflowed {
+			if v := row.Tags[name]; v != nil {
+				values[name] = v
+			} else if v := row.Fields[name]; v != nil {
+				values[name] = v
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		limited++
+		if w.overflowPolicy == OverflowPolicyFold {
+			foldValues(row, values)
+		}
+	}
+
+	logFields := []zap.Field{zap.String("database", batch.DB), zap.String("table", batch.Table), zap.Strings("columns", columnNames(overflow))}
+	if w.overflowPolicy == OverflowPolicyDrop {
+		w.telemetry.recordLimited(context.Background(), limited, limitColumnsPerTable, limitActionDropped, logFields...)
+		return tags, fields
+	}
+	w.telemetry.recordLimited(context.Background(), limited, limitColumnsPerTable, limitActionFolded, logFields...)
+	for _, field := range fields {
+		if field.Name == extraAttributesColumn {
+			return tags, fields
+		}
+	}
+	return tags, append(fields, Column{Name: extraAttributesColumn, Type: arrow.BinaryTypes.String})
+}
+
+// foldValues adds the values to the extra attributes of the row, a JSON object.
+func foldValues(row *Row, values map[string]any) {
+	if row.Fields == nil {
+		row.Fields = map[string]any{}
+	}
+	if s, ok := row.Fields[extraAttributesColumn].(string); ok {
+		var extra map[string]any
+		if json.Unmarshal([]byte(s), &extra) == nil {
+			for k, v := range extra {
+				if _, ok := values[k]; !ok {
+					values[k] = v
+				}
+			}
+		}
+	}
+	row.Fields[extraAttributesColumn] = toJSON(values)
+}
+
+// truncateValues truncates the string values longer than max bytes, without splitting a UTF-8
+// character. It returns true if a value was truncated.
+func truncateValues(values map[string]any, max int) bool {
+	truncated := false
+	for k, v := range values {
+		s, ok := v.(string)
+		if !ok || len(s) <= max {
+			continue
+		}
+		n := max
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		values[k] = s[:n]
+		truncated = true
+	}
+	return truncated
+}
+
+// columnNames returns the names of the columns.
+func columnNames(columns ...[]Column) []string {
+	var names []string
+	for _, list := range columns {
+		for _, column := range list {
+			names = append(names, column.Name)
+		}
+	}
+	return names
+}
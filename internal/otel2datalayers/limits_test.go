@@ -0,0 +1,185 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func testColumns(names ...string) []Column {
+	columns := make([]Column, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, Column{Name: name, Type: arrow.BinaryTypes.String})
+	}
+	return columns
+}
+
+func TestLimitColumns(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxColumns     int
+		existing       []string
+		partitions     []string
+		fields         []string
+		wantPartitions []string
+		wantFields     []string
+		wantOverflow   []string
+	}{
+		{
+			name:           "disabled",
+			partitions:     []string{"a", "b"},
+			fields:         []string{"v1", "v2"},
+			wantPartitions: []string{"a", "b"},
+			wantFields:     []string{"v1", "v2"},
+		},
+		{
+			name:           "new table within the limit",
+			maxColumns:     4,
+			partitions:     []string{"a", "b"},
+			fields:         []string{"v1", "v2"},
+			wantPartitions: []string{"a", "b"},
+			wantFields:     []string{"v1", "v2"},
+		},
+		{
+			name:           "fields are added before the tags",
+			maxColumns:     3,
+			partitions:     []string{"a", "b"},
+			fields:         []string{"v1", "v2"},
+			wantPartitions: []string{"a"},
+			wantFields:     []string{"v1", "v2"},
+			wantOverflow:   []string{"b"},
+		},
+		{
+			name:           "existing columns are kept over the limit",
+			maxColumns:     2,
+			existing:       []string{"ts", "host", "region", "value"},
+			partitions:     []string{"host", "region", "zone"},
+			fields:         []string{"value", "count"},
+			wantPartitions: []string{"host", "region"},
+			wantFields:     []string{"value"},
+			wantOverflow:   []string{"count", "zone"},
+		},
+		{
+			name:           "extra attributes column does not count",
+			maxColumns:     2,
+			existing:       []string{"ts", "host"},
+			partitions:     []string{"host", "region"},
+			fields:         []string{extraAttributesColumn, "value"},
+			wantPartitions: []string{"host"},
+			wantFields:     []string{extraAttributesColumn, "value"},
+			wantOverflow:   []string{"region"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(t, &DatalayerWritterConfig{MaxColumnsPerTable: tt.maxColumns})
+			if tt.existing != nil {
+				columns := map[string]arrow.DataType{}
+				for _, name := range tt.existing {
+					columns[name] = arrow.BinaryTypes.String
+				}
+				w.schema.setTable("db", "table", columns)
+			}
+
+			partitions, fields, overflow := w.limitColumns("db", "table", testColumns(tt.partitions...), testColumns(tt.fields...))
+			if got := columnNames(partitions); !reflect.DeepEqual(got, tt.wantPartitions) {
+				t.Errorf("limitColumns() partitions = %q, want %q", got, tt.wantPartitions)
+			}
+			if got := columnNames(fields); !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("limitColumns() fields = %q, want %q", got, tt.wantFields)
+			}
+			if got := columnNames(overflow); !reflect.DeepEqual(got, tt.wantOverflow) {
+				t.Errorf("limitColumns() overflow = %q, want %q", got, tt.wantOverflow)
+			}
+		})
+	}
+}
+
+func TestLimitBatchSeries(t *testing.T) {
+	newBatch := func() *TableBatch {
+		return &TableBatch{DB: "db", Table: "table", Rows: []Row{
+			{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0}},
+			{Tags: map[string]any{"host": "b"}, Fields: map[string]any{"value": 2.0}},
+			{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 3.0}},
+			{Tags: map[string]any{"host": "c"}, Fields: map[string]any{"value": 4.0}},
+		}}
+	}
+	admitted := []Row{
+		{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 1.0}},
+		{Tags: map[string]any{"host": "a"}, Fields: map[string]any{"value": 3.0}},
+	}
+
+	tests := []struct {
+		name        string
+		config      DatalayerWritterConfig
+		want        []Row
+		wantDropped int64
+	}{
+		{
+			name:   "disabled",
+			config: DatalayerWritterConfig{},
+			want:   newBatch().Rows,
+		},
+		{
+			// Written without their tags, the lines of the other series would overwrite each other.
+			name:        "dropped when folding",
+			config:      DatalayerWritterConfig{MaxSeriesPerTable: 1, SeriesInterval: time.Hour, OverflowPolicy: OverflowPolicyFold},
+			want:        admitted,
+			wantDropped: 2,
+		},
+		{
+			name:        "dropped when dropping",
+			config:      DatalayerWritterConfig{MaxSeriesPerTable: 1, SeriesInterval: time.Hour, OverflowPolicy: OverflowPolicyDrop},
+			want:        admitted,
+			wantDropped: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(t, &tt.config)
+			core, logs := observer.New(zap.WarnLevel)
+			w.telemetry.logger = zap.New(core)
+			batch := newBatch()
+			w.limitBatch(batch)
+			if !reflect.DeepEqual(batch.Rows, tt.want) {
+				t.Errorf("limitBatch() rows = %+v, want %+v", batch.Rows, tt.want)
+			}
+			if got := droppedLines(logs, dropReasonSeriesLimit); got != tt.wantDropped {
+				t.Errorf("limitBatch() counted %d dropped lines, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// droppedLines returns the number of lines logged as dropped for the reason.
+func droppedLines(logs *observer.ObservedLogs, reason string) int64 {
+	var count int64
+	for _, entry := range logs.FilterMessage("Dropped lines").All() {
+		fields := entry.ContextMap()
+		if fields["reason"] == reason {
+			count += fields["count"].(int64)
+		}
+	}
+	return count
+}
+
+func TestTruncateValues(t *testing.T) {
+	values := map[string]any{"short": "abc", "long": "abcdef", "multibyte": "aé", "number": int64(123456)}
+	if !truncateValues(values, 3) {
+		t.Error("truncateValues() = false, want true")
+	}
+	want := map[string]any{"short": "abc", "long": "abc", "multibyte": "aé", "number": int64(123456)}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("truncateValues() values = %v, want %v", values, want)
+	}
+
+	values = map[string]any{"multibyte": "aéb"}
+	truncateValues(values, 2)
+	if got := values["multibyte"]; got != "a" {
+		t.Errorf("truncateValues() splitting a character = %q, want %q", got, "a")
+	}
+}
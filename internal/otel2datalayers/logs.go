@@ -0,0 +1,107 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+type OtelLogsToDatalayersConfig struct {
+	Writer *DatalayerWritter
+	// Database is the database the log table is created in.
+	Database string
+	// Table is the table log records are written to.
+	Table string
+	// LogRecordDimensions are log record or resource attributes used as tag columns.
+	LogRecordDimensions []string
+}
+
+type OtelLogsToDatalayers struct {
+	writer     *DatalayerWritter
+	database   string
+	table      string
+	dimensions []string
+}
+
+func NewOtelLogsToDatalayers(config *OtelLogsToDatalayersConfig) (*OtelLogsToDatalayers, error) {
+	if config.Writer == nil {
+		return nil, errors.New("writer is nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("database is empty")
+	}
+	if config.Table == "" {
+		return nil, errors.New("log table is empty")
+	}
+	if len(config.LogRecordDimensions) == 0 {
+		return nil, errors.New("log record dimensions are empty")
+	}
+	return &OtelLogsToDatalayers{
+		writer:     config.Writer,
+		database:   config.Database,
+		table:      config.Table,
+		dimensions: config.LogRecordDimensions,
+	}, nil
+}
+
+func (c *OtelLogsToDatalayers) WriteLogs(ctx context.Context, ld plog.Logs) error {
+	batches := NewBatches()
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			sl := rl.ScopeLogs().At(j)
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				batches.Add(c.database, c.table, c.logRow(sl.LogRecords().At(k), sl.Scope(), rl.Resource()))
+			}
+		}
+	}
+
+	// The records are written to a single table: if its batch is to be retried, so are all of them.
+	_, err := c.writer.WriteBatches(ctx, batches.List())
+	return err
+}
+
+// logRow converts a log record to a row. The record attributes not used as dimensions
+// are kept in the `attributes` column as JSON.
+func (c *OtelLogsToDatalayers) logRow(record plog.LogRecord, scope pcommon.InstrumentationScope, resource pcommon.Resource) Row {
+	ts := record.Timestamp()
+	if ts == 0 {
+		ts = record.ObservedTimestamp()
+	}
+
+	row := Row{
+		Tags: make(map[string]any, len(c.dimensions)),
+		Fields: map[string]any{
+			"observed_time_unix_nano":  int64(record.ObservedTimestamp()),
+			"severity_number":          int64(record.SeverityNumber()),
+			"severity_text":            record.SeverityText(),
+			"body":                     record.Body().AsString(),
+			"trace_id":                 record.TraceID().String(),
+			"span_id":                  record.SpanID().String(),
+			"flags":                    int64(record.Flags()),
+			"scope_name":               scope.Name(),
+			"scope_version":            scope.Version(),
+			"dropped_attributes_count": int64(record.DroppedAttributesCount()),
+		},
+	}
+
+	// A record without any timestamp is written with the time it is inserted at, see Row.
+	if ts != 0 {
+		row.Timestamp = ts.AsTime()
+	}
+
+	used := make(map[string]struct{}, len(c.dimensions))
+	for _, k := range c.dimensions {
+		used[k] = struct{}{}
+		if v, ok := lookupAttribute(k, record.Attributes(), resource.Attributes()); ok {
+			row.Tags[k] = attributeValue(v)
+		} else {
+			row.Tags[k] = nil
+		}
+	}
+	row.Fields["attributes"] = attributesJSON(record.Attributes(), used)
+
+	return row
+}
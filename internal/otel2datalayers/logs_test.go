@@ -0,0 +1,37 @@
+package otel2datalayers
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+func TestLogRowTimestamp(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	observed := ts.Add(time.Second)
+	tests := []struct {
+		name      string
+		timestamp pcommon.Timestamp
+		observed  pcommon.Timestamp
+		want      time.Time
+	}{
+		{name: "timestamp", timestamp: pcommon.NewTimestampFromTime(ts), observed: pcommon.NewTimestampFromTime(observed), want: ts},
+		{name: "observed timestamp", observed: pcommon.NewTimestampFromTime(observed), want: observed},
+		// A zero time is written with the time of the insert, not at the Unix epoch.
+		{name: "no timestamp", want: time.Time{}},
+	}
+	c := &OtelLogsToDatalayers{dimensions: []string{"service.name"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := plog.NewLogRecord()
+			record.SetTimestamp(tt.timestamp)
+			record.SetObservedTimestamp(tt.observed)
+			row := c.logRow(record, pcommon.NewInstrumentationScope(), pcommon.NewResource())
+			if !row.Timestamp.Equal(tt.want) {
+				t.Errorf("logRow().Timestamp = %v, want %v", row.Timestamp, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package otel2datalayers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"go.opentelemetry.io/collector/consumer/consumererror"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
 )
 
 type MetricsSchema uint8
@@ -37,34 +42,158 @@ var MetricsSchemata = map[string]MetricsSchema{
 	MetricsSchemaOtelV1.String():               MetricsSchemaOtelV1,
 }
 
+// ZeroTimestampPolicy defines how data points without a timestamp are written.
+type ZeroTimestampPolicy uint8
+
+const (
+	_ ZeroTimestampPolicy = iota
+	// ZeroTimestampPolicyNow writes the data point with the time it is inserted at.
+	ZeroTimestampPolicyNow
+	// ZeroTimestampPolicyDrop drops the data point.
+	ZeroTimestampPolicyDrop
+)
+
+func (p ZeroTimestampPolicy) String() string {
+	switch p {
+	case ZeroTimestampPolicyNow:
+		return "now"
+	case ZeroTimestampPolicyDrop:
+		return "drop"
+	default:
+		panic("invalid ZeroTimestampPolicy")
+	}
+}
+
+var ZeroTimestampPolicies = map[string]ZeroTimestampPolicy{
+	ZeroTimestampPolicyNow.String():  ZeroTimestampPolicyNow,
+	ZeroTimestampPolicyDrop.String(): ZeroTimestampPolicyDrop,
+}
+
 // use to store metrics data for temporary
 type MetricsMultipleLines struct {
 	Lines      []MetricsSingleLine
-	Attributes map[string]string
+	Attributes map[string]any
 }
 type MetricsSingleLine struct {
-	Key        string
-	Value      interface{}
+	// Database is the name of the database the line is written to.
+	Database string
+	// Key is the name of the table the line is written to.
+	Key string
+	// Timestamp is the time of the data point, zero if the data point has none.
+	Timestamp time.Time
+	// StartTimestamp is the start time of cumulative data points, zero otherwise.
+	StartTimestamp time.Time
+	// Fields are the value columns of the line.
+	Fields     map[string]any
 	Type       int32
-	Metadata   map[string]string
-	Attributes map[string]string
+	Metadata   map[string]any
+	Attributes map[string]any
 }
 
-func WriteMetrics(ctx context.Context, md pmetric.Metrics) error {
+type OtelMetricsToDatalayersConfig struct {
+	Writer *DatalayerWritter
+	// Schema selects the table layout of the metrics.
+	Schema MetricsSchema
+	// ZeroTimestampPolicy defines how data points without a timestamp are written.
+	ZeroTimestampPolicy ZeroTimestampPolicy
+	// Router decides the database of the metrics, and their table with the telegraf-prometheus-v1 schema.
+	Router *MetricsRouter
+	// StartTimestamp writes the start time of cumulative data points to the `start_ts` column.
+	StartTimestamp bool
+}
+
+type OtelMetricsToDatalayers struct {
+	writer              *DatalayerWritter
+	zeroTimestampPolicy ZeroTimestampPolicy
+	startTimestamp      bool
+	router              *MetricsRouter
+	// routeTables tells whether the tables are named by the router, or by the schema layout.
+	routeTables bool
+	// metricLines converts a metric to the lines of the configured schema.
+	metricLines func(m pmetric.Metric) []MetricsSingleLine
+}
+
+func NewOtelMetricsToDatalayers(config *OtelMetricsToDatalayersConfig) (*OtelMetricsToDatalayers, error) {
+	if config.Writer == nil {
+		return nil, errors.New("writer is nil")
+	}
+	if config.Router == nil {
+		return nil, errors.New("router is nil")
+	}
+
+	c := &OtelMetricsToDatalayers{
+		writer:              config.Writer,
+		zeroTimestampPolicy: config.ZeroTimestampPolicy,
+		startTimestamp:      config.StartTimestamp,
+		router:              config.Router,
+		routeTables:         config.Schema == MetricsSchemaTelegrafPrometheusV1,
+	}
+	switch config.ZeroTimestampPolicy {
+	case ZeroTimestampPolicyNow, ZeroTimestampPolicyDrop:
+	default:
+		return nil, fmt.Errorf("unrecognized zero timestamp policy %d", config.ZeroTimestampPolicy)
+	}
+	switch config.Schema {
+	case MetricsSchemaTelegrafPrometheusV1:
+		c.metricLines = telegrafPrometheusV1Lines
+	case MetricsSchemaTelegrafPrometheusV2:
+		c.metricLines = telegrafPrometheusV2Lines
+	case MetricsSchemaOtelV1:
+		c.metricLines = otelV1Lines
+	default:
+		return nil, fmt.Errorf("unrecognized metrics schema %d", config.Schema)
+	}
+	return c, nil
+}
+
+// WriteMetrics converts the metrics and writes them to Datalayers, returning the errors of the writes.
+// If batches failed with retryable errors, the error carries the metrics of their tables only, so that
+// the exporter helper retries those metrics and not the ones already written.
+func (c *OtelMetricsToDatalayers) WriteMetrics(ctx context.Context, md pmetric.Metrics) error {
+	batches, tables := c.batches(ctx, md)
+	retry, err := c.writer.WriteBatches(ctx, batches.List())
+	if len(retry) == 0 {
+		return err
+	}
+
+	retryTables := tableKeys(retry)
+	retryMetrics := pmetric.NewMetrics()
+	md.CopyTo(retryMetrics)
+	i := 0
+	retryMetrics.ResourceMetrics().RemoveIf(func(rm pmetric.ResourceMetrics) bool {
+		rm.ScopeMetrics().RemoveIf(func(sm pmetric.ScopeMetrics) bool {
+			sm.Metrics().RemoveIf(func(pmetric.Metric) bool {
+				table := tables[i]
+				i++
+				return !retryTables[table]
+			})
+			return sm.Metrics().Len() == 0
+		})
+		return rm.ScopeMetrics().Len() == 0
+	})
+	return consumererror.NewMetrics(err, retryMetrics)
+}
+
+// batches converts the metrics to rows, grouped by table. It also returns the key of the table of
+// each metric, in the order of the metrics, see tableKey; the key is empty for the metrics not written.
+func (c *OtelMetricsToDatalayers) batches(ctx context.Context, md pmetric.Metrics) (*Batches, []string) {
+	var tables []string
+	batches := NewBatches()
 	for i := 0; i < md.ResourceMetrics().Len(); i++ {
 		newLines := MetricsMultipleLines{
 			Lines:      []MetricsSingleLine{},
-			Attributes: map[string]string{},
+			Attributes: map[string]any{},
 		}
 
-		deduplicateMap := map[string]any{}
+		droppedLines := 0
+		renamedLines := 0
 
 		rm := md.ResourceMetrics().At(i)
 
 		attrs := rm.Resource().Attributes()
 		attrs.Range(func(k string, v pcommon.Value) bool {
 			if len(v.AsString()) > 0 {
-				newLines.Attributes[k] = v.AsString()
+				newLines.Attributes[k] = attributeValue(v)
 			}
 			return true
 		})
@@ -74,212 +203,217 @@ func WriteMetrics(ctx context.Context, md pmetric.Metrics) error {
 			for k := 0; k < ilm.Metrics().Len(); k++ {
 				m := ilm.Metrics().At(k)
 
-				if _, ok := deduplicateMap[m.Name()]; ok {
+				lines := c.metricLines(m)
+				database, table := c.router.Route(rm.Resource(), ilm.Scope(), m)
+				if database == "" {
+					c.writer.telemetry.recordDropped(ctx, len(lines), dropReasonMissingDatabase, zap.String("metric", m.Name()))
+					tables = append(tables, "")
 					continue
-				} else {
-					deduplicateMap[m.Name()] = nil
 				}
 
-				switch m.Type() {
-				case pmetric.MetricTypeGauge:
-					for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
-						metricsSingleLine := MetricsSingleLine{
-							Key:        m.Name(),
-							Type:       int32(m.Type()),
-							Metadata:   map[string]string{},
-							Attributes: map[string]string{},
-						}
-
-						m.Metadata().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Metadata[k] = v.AsString()
-							return true
-						})
-						m.Gauge().DataPoints().At(i).Attributes().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Attributes[k] = v.AsString()
-							return true
-						})
-
-						metricsSingleLine.Value = m.Gauge().DataPoints().At(i).DoubleValue()
-						newLines.Lines = append(newLines.Lines, metricsSingleLine)
+				// The lines of a metric are written to a single table.
+				key := ""
+				if len(lines) > 0 {
+					if !c.routeTables {
+						table = lines[0].Key
 					}
-				case pmetric.MetricTypeSum:
-					for i := 0; i < m.Sum().DataPoints().Len(); i++ {
-						metricsSingleLine := MetricsSingleLine{
-							Key:        m.Name(),
-							Type:       int32(m.Type()),
-							Metadata:   map[string]string{},
-							Attributes: map[string]string{},
-						}
-
-						m.Metadata().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Metadata[k] = v.AsString()
-							return true
-						})
-						m.Sum().DataPoints().At(i).Attributes().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Attributes[k] = v.AsString()
-							return true
-						})
-
-						metricsSingleLine.Value = m.Sum().DataPoints().At(i).DoubleValue()
-						newLines.Lines = append(newLines.Lines, metricsSingleLine)
+					key = tableKey(database, table)
+				}
+				tables = append(tables, key)
+
+				for _, line := range lines {
+					if line.Timestamp.IsZero() && c.zeroTimestampPolicy == ZeroTimestampPolicyDrop {
+						droppedLines++
+						continue
 					}
-				case pmetric.MetricTypeHistogram:
-					for i := 0; i < m.Histogram().DataPoints().Len(); i++ {
-						metricsSingleLine := MetricsSingleLine{
-							Key:        m.Name(),
-							Type:       int32(m.Type()),
-							Metadata:   map[string]string{},
-							Attributes: map[string]string{},
-						}
-
-						m.Metadata().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Metadata[k] = v.AsString()
-							return true
-						})
-						m.Histogram().DataPoints().At(i).Attributes().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Attributes[k] = v.AsString()
-							return true
-						})
-
-						metricsSingleLine.Value = m.Histogram().DataPoints().At(i).Sum()
-						newLines.Lines = append(newLines.Lines, metricsSingleLine)
+					if setScopeTags(line, ilm.Scope()) {
+						renamedLines++
 					}
-				case pmetric.MetricTypeSummary:
-					for i := 0; i < m.Summary().DataPoints().Len(); i++ {
-						metricsSingleLine := MetricsSingleLine{
-							Key:        m.Name(),
-							Type:       int32(m.Type()),
-							Metadata:   map[string]string{},
-							Attributes: map[string]string{},
-						}
-
-						m.Metadata().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Metadata[k] = v.AsString()
-							return true
-						})
-						m.Summary().DataPoints().At(i).Attributes().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Attributes[k] = v.AsString()
-							return true
-						})
-
-						metricsSingleLine.Value = m.Summary().DataPoints().At(i).Sum()
-						newLines.Lines = append(newLines.Lines, metricsSingleLine)
+					if c.startTimestamp && !line.StartTimestamp.IsZero() {
+						line.Fields["start_ts"] = line.StartTimestamp
 					}
-				case pmetric.MetricTypeExponentialHistogram:
-					for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
-						metricsSingleLine := MetricsSingleLine{
-							Key:        m.Name(),
-							Type:       int32(m.Type()),
-							Metadata:   map[string]string{},
-							Attributes: map[string]string{},
-						}
-
-						m.Metadata().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Metadata[k] = v.AsString()
-							return true
-						})
-						m.ExponentialHistogram().DataPoints().At(i).Attributes().Range(func(k string, v pcommon.Value) bool {
-							metricsSingleLine.Attributes[k] = v.AsString()
-							return true
-						})
-
-						metricsSingleLine.Value = m.ExponentialHistogram().DataPoints().At(i).Sum()
-						newLines.Lines = append(newLines.Lines, metricsSingleLine)
+					line.Database = database
+					if c.routeTables {
+						line.Key = table
 					}
+					newLines.Lines = append(newLines.Lines, line)
 				}
 			}
 		}
-		enqueueNewlines(newLines)
+		c.writer.telemetry.recordDropped(ctx, droppedLines, dropReasonZeroTimestamp)
+		if renamedLines > 0 {
+			c.writer.telemetry.logger.Warn("Renamed data point attributes named like the scope tags",
+				zap.Int("count", renamedLines), zap.String("prefix", collisionPrefix))
+		}
+		newLines.addRows(batches)
 	}
+	return batches, tables
+}
 
-	return nil
+// setScopeTags sets the scope tags of the line: metrics of the same name from different scopes
+// share a table, the scope tags keep their series apart. The data point attributes named like the
+// scope tags are prefixed with collisionPrefix, see resolveCollisions. It returns true if an
+// attribute was renamed.
+func setScopeTags(line MetricsSingleLine, scope pcommon.InstrumentationScope) bool {
+	scopeTags := map[string]string{"scope_name": scope.Name(), "scope_version": scope.Version()}
+	renamed := false
+	for k, v := range scopeTags {
+		if value, ok := line.Attributes[k]; ok {
+			name := collisionPrefix + k
+			for _, ok := line.Attributes[name]; ok; _, ok = line.Attributes[name] {
+				name = collisionPrefix + name
+			}
+			line.Attributes[name] = value
+			renamed = true
+		}
+		line.Attributes[k] = v
+	}
+	return renamed
 }
 
-var metricQueue = make(chan MetricsMultipleLines, 1000)
+// dataPoint is implemented by the data points of all the metric types.
+type dataPoint interface {
+	Attributes() pcommon.Map
+	StartTimestamp() pcommon.Timestamp
+	Timestamp() pcommon.Timestamp
+}
 
-func enqueueNewlines(metrics MetricsMultipleLines) {
-	metricQueue <- metrics
+// newMetricLine returns a line of the metric written to the table, with the metric metadata,
+// the data point attributes and timestamps. The start timestamp is only kept for cumulative data points.
+func newMetricLine(m pmetric.Metric, table string, dp dataPoint) MetricsSingleLine {
+	line := MetricsSingleLine{
+		Key:        table,
+		Type:       int32(m.Type()),
+		Fields:     map[string]any{},
+		Metadata:   map[string]any{},
+		Attributes: map[string]any{},
+	}
+	if ts := dp.Timestamp(); ts != 0 {
+		line.Timestamp = ts.AsTime()
+	}
+	if start := dp.StartTimestamp(); start != 0 && isCumulative(m) {
+		line.StartTimestamp = start.AsTime()
+	}
+	m.Metadata().Range(func(k string, v pcommon.Value) bool {
+		line.Metadata[k] = attributeValue(v)
+		return true
+	})
+	dp.Attributes().Range(func(k string, v pcommon.Value) bool {
+		line.Attributes[k] = attributeValue(v)
+		return true
+	})
+	return line
 }
 
-func (w *DatalayerWritter) ProcessMetrics(ctx context.Context) {
-	for {
-		select {
-		case metrics := <-metricQueue:
-			fmt.Println("Received metrics: ", metrics)
-			w.concatenateSql(metrics)
-		case <-ctx.Done():
-			return
-		}
+// numberValue returns the value of a gauge or sum data point: an int64 for integer points,
+// so that they are stored without losing precision, a float64 for double points and nil otherwise.
+func numberValue(dp pmetric.NumberDataPoint) any {
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		return dp.IntValue()
+	case pmetric.NumberDataPointValueTypeDouble:
+		return dp.DoubleValue()
+	default:
+		return nil
 	}
 }
 
-func addquote(v string) string {
-	return fmt.Sprintf("`%s`", v)
+// isCumulative returns whether the data points of the metric use the cumulative aggregation temporality.
+func isCumulative(m pmetric.Metric) bool {
+	switch m.Type() {
+	case pmetric.MetricTypeSum:
+		return m.Sum().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	case pmetric.MetricTypeHistogram:
+		return m.Histogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	case pmetric.MetricTypeExponentialHistogram:
+		return m.ExponentialHistogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	default:
+		return false
+	}
 }
 
-func addSingleQuote(v string) string {
-	return fmt.Sprintf("'%s'", v)
+// exponentialHistogramFields returns the columns storing an exponential histogram data point losslessly.
+// The bucket counts are stored as JSON arrays, the index of the first bucket being the offset.
+func exponentialHistogramFields(dp pmetric.ExponentialHistogramDataPoint) map[string]any {
+	fields := map[string]any{
+		"count":                  int64(dp.Count()),
+		"scale":                  int64(dp.Scale()),
+		"zero_count":             int64(dp.ZeroCount()),
+		"zero_threshold":         dp.ZeroThreshold(),
+		"positive_offset":        int64(dp.Positive().Offset()),
+		"positive_bucket_counts": bucketCountsJSON(dp.Positive().BucketCounts()),
+		"negative_offset":        int64(dp.Negative().Offset()),
+		"negative_bucket_counts": bucketCountsJSON(dp.Negative().BucketCounts()),
+	}
+	if dp.HasSum() {
+		fields["sum"] = dp.Sum()
+	}
+	if dp.HasMin() {
+		fields["min"] = dp.Min()
+	}
+	if dp.HasMax() {
+		fields["max"] = dp.Max()
+	}
+	return fields
 }
 
-func (w *DatalayerWritter) concatenateSql(metrics MetricsMultipleLines) {
-	dbName := ""
-	partitionsMultiple := []string{}
-	partitionFieldValuesMultiple := []string{}
-	for k, v := range metrics.Attributes {
-		// 用 service.name 字段分表， 实际为 Job name 中 resource_type/instance/cluster_name~${host} 的 resource_type
-		if k == "service.name" {
-			dbName = "metrics_" + v
-		}
-		partitionsMultiple = append(partitionsMultiple, addquote(k))
-		partitionFieldValuesMultiple = append(partitionFieldValuesMultiple, addSingleQuote(v))
+// bucketCountsJSON encodes the bucket counts as a JSON array, `[]` if there are no buckets.
+func bucketCountsJSON(counts pcommon.UInt64Slice) string {
+	raw := make([]uint64, 0, counts.Len())
+	for i := 0; i < counts.Len(); i++ {
+		raw = append(raw, counts.At(i))
 	}
+	return toJSON(raw)
+}
 
-	if dbName == "" {
-		return // todo: 处理没有 service.name 的情况
+// explicitBoundsJSON encodes the bucket bounds as a JSON array, `[]` if there are no bounds.
+func explicitBoundsJSON(bounds pcommon.Float64Slice) string {
+	raw := make([]float64, 0, bounds.Len())
+	for i := 0; i < bounds.Len(); i++ {
+		raw = append(raw, bounds.At(i))
 	}
+	return toJSON(raw)
+}
 
-	for _, metric := range metrics.Lines {
-		partitions := partitionsMultiple[:]
-		partitionFieldValues := partitionFieldValuesMultiple[:]
-		for k, v := range metric.Attributes {
-			partitions = append(partitions, addquote(k))
-			partitionFieldValues = append(partitionFieldValues, addSingleQuote(v))
-		}
+// formatBound formats a bucket bound or a quantile as a tag value.
+func formatBound(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
 
-		tableName := metric.Key
-		valueType := metric.Type
-		value := metric.Value
-		meta := metric.Metadata
+// addquote quotes an identifier, such as a database, table or column name, for a SQL statement.
+// The backticks it contains are doubled, so that it cannot end the quoted identifier.
+func addquote(v string) string {
+	return "`" + strings.ReplaceAll(v, "`", "``") + "`"
+}
 
-		metaValues := []string{}
-		fields := []string{}
-		for k, v := range meta {
-			fields = append(fields, addquote(k))
-			metaValues = append(metaValues, addSingleQuote(v))
-		}
+// addSingleQuote quotes a string literal for a SQL statement. The single quotes it contains
+// are doubled, so that it cannot end the literal. The values of the rows are never written as
+// literals: they are bound to the parameters of prepared statements.
+func addSingleQuote(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
 
-		err := w.CheckDBAndTable(dbName, addquote(tableName), partitions, fields, valueType)
-		if err != nil {
-			fmt.Printf("\nFailed to check table: %s \n\n", err.Error())
-			return
+// addRows converts the lines to rows and adds them to the batches of their tables.
+func (metrics MetricsMultipleLines) addRows(batches *Batches) {
+	for _, metric := range metrics.Lines {
+		row := Row{
+			Timestamp: metric.Timestamp,
+			Tags:      make(map[string]any, len(metrics.Attributes)+len(metric.Attributes)),
+			Fields:    make(map[string]any, len(metric.Metadata)+1),
 		}
-
-		sql := "INSERT INTO %s.`%s` (%s) VALUES (%s)"
-		columns := strings.Join(append(partitions, fields...), ",")
-		values := strings.Join(append(partitionFieldValues, metaValues...), ",")
-
-		columns = columns + ", val"
-		values = values + ", " + fmt.Sprintf("%v", value)
-
-		sql = fmt.Sprintf(sql, dbName, tableName, columns, values)
-		fmt.Println("to execute the sql: ", sql)
-
-		records, err := w.client.Execute(sql) // todo: maybe need to set the instance_name field
-		if err != nil {
-			fmt.Printf("\nFailed to insert metrics: %s\nsql: %s\n\n", err.Error(), sql)
-			return
+		for k, v := range metrics.Attributes {
+			row.Tags[k] = v
+		}
+		for k, v := range metric.Attributes {
+			row.Tags[k] = v
+		}
+		for k, v := range metric.Metadata {
+			row.Fields[k] = v
+		}
+		for k, v := range metric.Fields {
+			row.Fields[k] = v
 		}
-		defer releaseRecords(records)
 
+		batches.Add(metric.Database, metric.Key, row)
 	}
 }
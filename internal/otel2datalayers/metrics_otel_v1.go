@@ -0,0 +1,99 @@
+package otel2datalayers
+
+import (
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// otelMetricNameTag is the tag holding the metric name in the otel-v1 layout.
+const otelMetricNameTag = "metric_name"
+
+// otelV1Lines converts a metric to the otel-v1 layout: one table per metric type (`gauge`, `sum`,
+// `histogram`, `exponential_histogram` and `summary`), one line per data point, with columns
+// following the OpenTelemetry data model. Gauges and sums write double values to the `value`
+// column and integer values to the BIGINT `value_int` column. Bucket bounds, bucket counts and
+// quantiles are stored as JSON arrays.
+func otelV1Lines(m pmetric.Metric) []MetricsSingleLine {
+	var lines []MetricsSingleLine
+	newLine := func(table string, dp dataPoint) MetricsSingleLine {
+		line := newMetricLine(m, table, dp)
+		line.Attributes[otelMetricNameTag] = m.Name()
+		line.Fields["description"] = m.Description()
+		line.Fields["unit"] = m.Unit()
+		return line
+	}
+
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			dp := m.Gauge().DataPoints().At(i)
+			line := newLine("gauge", dp)
+			setOtelNumberValue(line, dp)
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeSum:
+		for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+			dp := m.Sum().DataPoints().At(i)
+			line := newLine("sum", dp)
+			setOtelNumberValue(line, dp)
+			line.Fields["is_monotonic"] = m.Sum().IsMonotonic()
+			line.Fields["aggregation_temporality"] = m.Sum().AggregationTemporality().String()
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < m.Histogram().DataPoints().Len(); i++ {
+			dp := m.Histogram().DataPoints().At(i)
+			line := newLine("histogram", dp)
+			line.Fields["count"] = int64(dp.Count())
+			if dp.HasSum() {
+				line.Fields["sum"] = dp.Sum()
+			}
+			if dp.HasMin() {
+				line.Fields["min"] = dp.Min()
+			}
+			if dp.HasMax() {
+				line.Fields["max"] = dp.Max()
+			}
+			line.Fields["explicit_bounds"] = explicitBoundsJSON(dp.ExplicitBounds())
+			line.Fields["bucket_counts"] = bucketCountsJSON(dp.BucketCounts())
+			line.Fields["aggregation_temporality"] = m.Histogram().AggregationTemporality().String()
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
+			dp := m.ExponentialHistogram().DataPoints().At(i)
+			line := newLine("exponential_histogram", dp)
+			for k, v := range exponentialHistogramFields(dp) {
+				line.Fields[k] = v
+			}
+			line.Fields["aggregation_temporality"] = m.ExponentialHistogram().AggregationTemporality().String()
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < m.Summary().DataPoints().Len(); i++ {
+			dp := m.Summary().DataPoints().At(i)
+			line := newLine("summary", dp)
+			line.Fields["count"] = int64(dp.Count())
+			line.Fields["sum"] = dp.Sum()
+			quantiles := make([]map[string]float64, 0, dp.QuantileValues().Len())
+			for q := 0; q < dp.QuantileValues().Len(); q++ {
+				quantiles = append(quantiles, map[string]float64{
+					"quantile": dp.QuantileValues().At(q).Quantile(),
+					"value":    dp.QuantileValues().At(q).Value(),
+				})
+			}
+			line.Fields["quantile_values"] = toJSON(quantiles)
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// setOtelNumberValue writes the value of the data point to the column matching its type.
+func setOtelNumberValue(line MetricsSingleLine, dp pmetric.NumberDataPoint) {
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		line.Fields["value_int"] = dp.IntValue()
+	case pmetric.NumberDataPointValueTypeDouble:
+		line.Fields["value"] = dp.DoubleValue()
+	}
+}
@@ -0,0 +1,76 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestOtelV1Lines(t *testing.T) {
+	exponential := pmetric.NewMetric()
+	exponential.SetName("sizes")
+	exponential.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	newExponentialHistogram(0, 1, 0, nil, 2, []uint64{3}).CopyTo(exponential.ExponentialHistogram().DataPoints().AppendEmpty())
+
+	tests := []struct {
+		name   string
+		metric pmetric.Metric
+		want   []testLine
+	}{
+		{
+			name:   "int and double gauge",
+			metric: newGauge("temperature", int64(20), 20.5),
+			want: []testLine{
+				{Key: "gauge", Attributes: map[string]any{"metric_name": "temperature"}, Fields: map[string]any{"description": "", "unit": "", "value_int": int64(20)}},
+				{Key: "gauge", Attributes: map[string]any{"metric_name": "temperature"}, Fields: map[string]any{"description": "", "unit": "", "value": 20.5}},
+			},
+		},
+		{
+			name:   "sum",
+			metric: newSum("requests", true, 3),
+			want: []testLine{
+				{Key: "sum", Attributes: map[string]any{"metric_name": "requests"}, Fields: map[string]any{
+					"description": "", "unit": "", "value": 3.0, "is_monotonic": true, "aggregation_temporality": "Delta",
+				}},
+			},
+		},
+		{
+			name:   "histogram",
+			metric: newHistogram("latency", []float64{0.1, 1}, []uint64{2, 3, 1}, 4.5),
+			want: []testLine{
+				{Key: "histogram", Attributes: map[string]any{"metric_name": "latency"}, Fields: map[string]any{
+					"description": "", "unit": "", "count": int64(6), "sum": 4.5,
+					"explicit_bounds": "[0.1,1]", "bucket_counts": "[2,3,1]", "aggregation_temporality": "Delta",
+				}},
+			},
+		},
+		{
+			name:   "exponential histogram",
+			metric: exponential,
+			want: []testLine{
+				{Key: "exponential_histogram", Attributes: map[string]any{"metric_name": "sizes"}, Fields: map[string]any{
+					"description": "", "unit": "", "count": int64(4), "scale": int64(0), "zero_count": int64(1), "zero_threshold": 0.0,
+					"positive_offset": int64(2), "positive_bucket_counts": "[3]", "negative_offset": int64(0), "negative_bucket_counts": "[]",
+					"aggregation_temporality": "Delta",
+				}},
+			},
+		},
+		{
+			name:   "summary",
+			metric: newSummary("rpc", 10, 5, map[float64]float64{0.5: 0.4}, 0.5),
+			want: []testLine{
+				{Key: "summary", Attributes: map[string]any{"metric_name": "rpc"}, Fields: map[string]any{
+					"description": "", "unit": "", "count": int64(10), "sum": 5.0, "quantile_values": `[{"quantile":0.5,"value":0.4}]`,
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testLines(otelV1Lines(tt.metric)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("otelV1Lines() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package otel2datalayers
+
+import (
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// telegrafPrometheusV1Lines converts a metric to the telegraf-prometheus-v1 layout: one table per
+// metric name, the value columns being named after the metric type.
+//   - gauge: `gauge`
+//   - sum: `counter` if monotonic, `gauge` otherwise
+//     The value column is BIGINT for integer data points and DOUBLE for double data points.
+//   - histogram: one line per bucket, see histogramLines
+//   - summary: one line per quantile, see summaryLines
+//   - exponential histogram: one line per data point, see exponentialHistogramFields
+func telegrafPrometheusV1Lines(m pmetric.Metric) []MetricsSingleLine {
+	var lines []MetricsSingleLine
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			dp := m.Gauge().DataPoints().At(i)
+			line := newMetricLine(m, m.Name(), dp)
+			line.Fields["gauge"] = numberValue(dp)
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeSum:
+		field := "gauge"
+		if m.Sum().IsMonotonic() {
+			field = "counter"
+		}
+		for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+			dp := m.Sum().DataPoints().At(i)
+			line := newMetricLine(m, m.Name(), dp)
+			line.Fields[field] = numberValue(dp)
+			lines = append(lines, line)
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < m.Histogram().DataPoints().Len(); i++ {
+			lines = append(lines, histogramLines(m, m.Histogram().DataPoints().At(i))...)
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < m.Summary().DataPoints().Len(); i++ {
+			lines = append(lines, summaryLines(m, m.Summary().DataPoints().At(i))...)
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
+			dp := m.ExponentialHistogram().DataPoints().At(i)
+			line := newMetricLine(m, m.Name(), dp)
+			for k, v := range exponentialHistogramFields(dp) {
+				line.Fields[k] = v
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// histogramLines converts a histogram data point to one line per bucket. The upper bound of the
+// bucket is written to the `le` tag and the cumulative count of the bucket to the `bucket_count`
+// column, so that quantiles can be computed the same way as from Prometheus histograms.
+// The count, sum, min and max of the data point are repeated on every line.
+func histogramLines(m pmetric.Metric, dp pmetric.HistogramDataPoint) []MetricsSingleLine {
+	newLine := func(le string, bucketCount uint64) MetricsSingleLine {
+		line := newMetricLine(m, m.Name(), dp)
+		line.Attributes["le"] = le
+		line.Fields["bucket_count"] = int64(bucketCount)
+		line.Fields["count"] = int64(dp.Count())
+		if dp.HasSum() {
+			line.Fields["sum"] = dp.Sum()
+		}
+		if dp.HasMin() {
+			line.Fields["min"] = dp.Min()
+		}
+		if dp.HasMax() {
+			line.Fields["max"] = dp.Max()
+		}
+		return line
+	}
+
+	bounds := dp.ExplicitBounds()
+	counts := dp.BucketCounts()
+	if counts.Len() == 0 {
+		// A histogram without buckets only carries the count and the sum.
+		return []MetricsSingleLine{newLine("+Inf", dp.Count())}
+	}
+
+	lines := make([]MetricsSingleLine, 0, counts.Len())
+	var cumulative uint64
+	for b := 0; b < counts.Len(); b++ {
+		cumulative += counts.At(b)
+		le := "+Inf"
+		if b < bounds.Len() {
+			le = formatBound(bounds.At(b))
+		}
+		lines = append(lines, newLine(le, cumulative))
+	}
+	return lines
+}
+
+// summaryLines converts a summary data point to one line per quantile. The quantile is written
+// to the `quantile` tag and its value to the `value` column. The count and sum of the data point
+// are repeated on every line. A data point without quantiles is written as a single line with
+// an empty `quantile` tag.
+func summaryLines(m pmetric.Metric, dp pmetric.SummaryDataPoint) []MetricsSingleLine {
+	newLine := func(quantile string) MetricsSingleLine {
+		line := newMetricLine(m, m.Name(), dp)
+		line.Attributes["quantile"] = quantile
+		line.Fields["count"] = int64(dp.Count())
+		line.Fields["sum"] = dp.Sum()
+		return line
+	}
+
+	quantiles := dp.QuantileValues()
+	if quantiles.Len() == 0 {
+		return []MetricsSingleLine{newLine("")}
+	}
+
+	lines := make([]MetricsSingleLine, 0, quantiles.Len())
+	for q := 0; q < quantiles.Len(); q++ {
+		line := newLine(formatBound(quantiles.At(q).Quantile()))
+		line.Fields["value"] = quantiles.At(q).Value()
+		lines = append(lines, line)
+	}
+	return lines
+}
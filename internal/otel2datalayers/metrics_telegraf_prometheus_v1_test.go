@@ -0,0 +1,149 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// testLine is the part of a line the layout tests compare.
+type testLine struct {
+	Key        string
+	Attributes map[string]any
+	Fields     map[string]any
+}
+
+func testLines(lines []MetricsSingleLine) []testLine {
+	got := make([]testLine, 0, len(lines))
+	for _, line := range lines {
+		got = append(got, testLine{Key: line.Key, Attributes: line.Attributes, Fields: line.Fields})
+	}
+	return got
+}
+
+func newGauge(name string, values ...any) pmetric.Metric {
+	m := pmetric.NewMetric()
+	m.SetName(name)
+	dps := m.SetEmptyGauge().DataPoints()
+	for _, v := range values {
+		dp := dps.AppendEmpty()
+		switch v := v.(type) {
+		case int64:
+			dp.SetIntValue(v)
+		case float64:
+			dp.SetDoubleValue(v)
+		}
+	}
+	return m
+}
+
+func newSum(name string, monotonic bool, value float64) pmetric.Metric {
+	m := pmetric.NewMetric()
+	m.SetName(name)
+	sum := m.SetEmptySum()
+	sum.SetIsMonotonic(monotonic)
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	sum.DataPoints().AppendEmpty().SetDoubleValue(value)
+	return m
+}
+
+func newHistogram(name string, bounds []float64, counts []uint64, sum float64) pmetric.Metric {
+	m := pmetric.NewMetric()
+	m.SetName(name)
+	histogram := m.SetEmptyHistogram()
+	histogram.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	dp := histogram.DataPoints().AppendEmpty()
+	dp.ExplicitBounds().FromRaw(bounds)
+	dp.BucketCounts().FromRaw(counts)
+	var count uint64
+	for _, c := range counts {
+		count += c
+	}
+	dp.SetCount(count)
+	dp.SetSum(sum)
+	return m
+}
+
+func newSummary(name string, count uint64, sum float64, quantiles map[float64]float64, order ...float64) pmetric.Metric {
+	m := pmetric.NewMetric()
+	m.SetName(name)
+	dp := m.SetEmptySummary().DataPoints().AppendEmpty()
+	dp.SetCount(count)
+	dp.SetSum(sum)
+	for _, q := range order {
+		quantile := dp.QuantileValues().AppendEmpty()
+		quantile.SetQuantile(q)
+		quantile.SetValue(quantiles[q])
+	}
+	return m
+}
+
+func TestTelegrafPrometheusV1Lines(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric pmetric.Metric
+		want   []testLine
+	}{
+		{
+			name:   "int and double gauge",
+			metric: newGauge("temperature", int64(20), 20.5),
+			want: []testLine{
+				{Key: "temperature", Attributes: map[string]any{}, Fields: map[string]any{"gauge": int64(20)}},
+				{Key: "temperature", Attributes: map[string]any{}, Fields: map[string]any{"gauge": 20.5}},
+			},
+		},
+		{
+			name:   "monotonic sum",
+			metric: newSum("requests", true, 3),
+			want: []testLine{
+				{Key: "requests", Attributes: map[string]any{}, Fields: map[string]any{"counter": 3.0}},
+			},
+		},
+		{
+			name:   "non-monotonic sum",
+			metric: newSum("queue_size", false, 3),
+			want: []testLine{
+				{Key: "queue_size", Attributes: map[string]any{}, Fields: map[string]any{"gauge": 3.0}},
+			},
+		},
+		{
+			name:   "histogram with cumulative buckets",
+			metric: newHistogram("latency", []float64{0.1, 1}, []uint64{2, 3, 1}, 4.5),
+			want: []testLine{
+				{Key: "latency", Attributes: map[string]any{"le": "0.1"}, Fields: map[string]any{"bucket_count": int64(2), "count": int64(6), "sum": 4.5}},
+				{Key: "latency", Attributes: map[string]any{"le": "1"}, Fields: map[string]any{"bucket_count": int64(5), "count": int64(6), "sum": 4.5}},
+				{Key: "latency", Attributes: map[string]any{"le": "+Inf"}, Fields: map[string]any{"bucket_count": int64(6), "count": int64(6), "sum": 4.5}},
+			},
+		},
+		{
+			name:   "histogram without buckets",
+			metric: newHistogram("latency", nil, nil, 0),
+			want: []testLine{
+				{Key: "latency", Attributes: map[string]any{"le": "+Inf"}, Fields: map[string]any{"bucket_count": int64(0), "count": int64(0), "sum": 0.0}},
+			},
+		},
+		{
+			name:   "summary",
+			metric: newSummary("rpc", 10, 5, map[float64]float64{0.5: 0.4, 0.99: 1.2}, 0.5, 0.99),
+			want: []testLine{
+				{Key: "rpc", Attributes: map[string]any{"quantile": "0.5"}, Fields: map[string]any{"count": int64(10), "sum": 5.0, "value": 0.4}},
+				{Key: "rpc", Attributes: map[string]any{"quantile": "0.99"}, Fields: map[string]any{"count": int64(10), "sum": 5.0, "value": 1.2}},
+			},
+		},
+		{
+			name:   "summary without quantiles",
+			metric: newSummary("rpc", 10, 5, nil),
+			want: []testLine{
+				{Key: "rpc", Attributes: map[string]any{"quantile": ""}, Fields: map[string]any{"count": int64(10), "sum": 5.0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testLines(telegrafPrometheusV1Lines(tt.metric)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("telegrafPrometheusV1Lines() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package otel2datalayers
+
+import (
+	"math"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	// prometheusTable is the single table of the telegraf-prometheus-v2 layout.
+	prometheusTable = "prometheus"
+	// prometheusNameTag is the tag holding the Prometheus series name.
+	prometheusNameTag = "__name__"
+)
+
+// telegrafPrometheusV2Lines converts a metric to the telegraf-prometheus-v2 layout: all the metrics
+// are written to the `prometheus` table, the series name being in the `__name__` tag and the
+// sample in the `value` column. The value of integer gauges and sums is also written to the BIGINT
+// `value_int` column, as converting it to a double loses precision above 2^53. Histograms and
+// summaries are split into series the way Prometheus exposes them:
+//   - histogram: `<name>_bucket` with an `le` tag, `<name>_count`, `<name>_sum`, `<name>_min`, `<name>_max`
+//   - summary: `<name>` with a `quantile` tag, `<name>_count`, `<name>_sum`
+//   - exponential histogram: same as histogram, the bucket bounds being computed from the scale
+func telegrafPrometheusV2Lines(m pmetric.Metric) []MetricsSingleLine {
+	var lines []MetricsSingleLine
+	newLine := func(name string, dp dataPoint, value float64) MetricsSingleLine {
+		line := newMetricLine(m, prometheusTable, dp)
+		line.Attributes[prometheusNameTag] = name
+		line.Fields["value"] = value
+		return line
+	}
+
+	newNumberLine := func(dp pmetric.NumberDataPoint) MetricsSingleLine {
+		if dp.ValueType() == pmetric.NumberDataPointValueTypeInt {
+			line := newLine(m.Name(), dp, float64(dp.IntValue()))
+			line.Fields["value_int"] = dp.IntValue()
+			return line
+		}
+		return newLine(m.Name(), dp, dp.DoubleValue())
+	}
+
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			lines = append(lines, newNumberLine(m.Gauge().DataPoints().At(i)))
+		}
+	case pmetric.MetricTypeSum:
+		for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+			lines = append(lines, newNumberLine(m.Sum().DataPoints().At(i)))
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < m.Histogram().DataPoints().Len(); i++ {
+			dp := m.Histogram().DataPoints().At(i)
+			bounds := dp.ExplicitBounds()
+			counts := dp.BucketCounts()
+			var cumulative uint64
+			for b := 0; b < counts.Len(); b++ {
+				cumulative += counts.At(b)
+				le := "+Inf"
+				if b < bounds.Len() {
+					le = formatBound(bounds.At(b))
+				}
+				line := newLine(m.Name()+"_bucket", dp, float64(cumulative))
+				line.Attributes["le"] = le
+				lines = append(lines, line)
+			}
+			lines = append(lines, newLine(m.Name()+"_count", dp, float64(dp.Count())))
+			if dp.HasSum() {
+				lines = append(lines, newLine(m.Name()+"_sum", dp, dp.Sum()))
+			}
+			if dp.HasMin() {
+				lines = append(lines, newLine(m.Name()+"_min", dp, dp.Min()))
+			}
+			if dp.HasMax() {
+				lines = append(lines, newLine(m.Name()+"_max", dp, dp.Max()))
+			}
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < m.Summary().DataPoints().Len(); i++ {
+			dp := m.Summary().DataPoints().At(i)
+			for q := 0; q < dp.QuantileValues().Len(); q++ {
+				quantile := dp.QuantileValues().At(q)
+				line := newLine(m.Name(), dp, quantile.Value())
+				line.Attributes["quantile"] = formatBound(quantile.Quantile())
+				lines = append(lines, line)
+			}
+			lines = append(lines, newLine(m.Name()+"_count", dp, float64(dp.Count())))
+			lines = append(lines, newLine(m.Name()+"_sum", dp, dp.Sum()))
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
+			dp := m.ExponentialHistogram().DataPoints().At(i)
+			for _, bucket := range exponentialBuckets(dp) {
+				line := newLine(m.Name()+"_bucket", dp, float64(bucket.count))
+				line.Attributes["le"] = bucket.le
+				lines = append(lines, line)
+			}
+			lines = append(lines, newLine(m.Name()+"_count", dp, float64(dp.Count())))
+			if dp.HasSum() {
+				lines = append(lines, newLine(m.Name()+"_sum", dp, dp.Sum()))
+			}
+			if dp.HasMin() {
+				lines = append(lines, newLine(m.Name()+"_min", dp, dp.Min()))
+			}
+			if dp.HasMax() {
+				lines = append(lines, newLine(m.Name()+"_max", dp, dp.Max()))
+			}
+		}
+	}
+	return lines
+}
+
+type cumulativeBucket struct {
+	le    string
+	count uint64
+}
+
+// exponentialBuckets converts the buckets of an exponential histogram to cumulative buckets with
+// explicit upper bounds. The bucket of index i covers (base^i, base^(i+1)] with base = 2^(2^-scale),
+// and mirrors it for negative values. The zero bucket is bounded by the zero threshold.
+func exponentialBuckets(dp pmetric.ExponentialHistogramDataPoint) []cumulativeBucket {
+	base := math.Exp2(math.Exp2(-float64(dp.Scale())))
+	negative := dp.Negative()
+	positive := dp.Positive()
+	buckets := make([]cumulativeBucket, 0, negative.BucketCounts().Len()+positive.BucketCounts().Len()+2)
+
+	var cumulative uint64
+	for b := negative.BucketCounts().Len() - 1; b >= 0; b-- {
+		cumulative += negative.BucketCounts().At(b)
+		upper := -math.Pow(base, float64(int(negative.Offset())+b))
+		buckets = append(buckets, cumulativeBucket{le: formatBound(upper), count: cumulative})
+	}
+	cumulative += dp.ZeroCount()
+	buckets = append(buckets, cumulativeBucket{le: formatBound(dp.ZeroThreshold()), count: cumulative})
+	for b := 0; b < positive.BucketCounts().Len(); b++ {
+		cumulative += positive.BucketCounts().At(b)
+		upper := math.Pow(base, float64(int(positive.Offset())+b+1))
+		buckets = append(buckets, cumulativeBucket{le: formatBound(upper), count: cumulative})
+	}
+	return append(buckets, cumulativeBucket{le: "+Inf", count: dp.Count()})
+}
@@ -0,0 +1,117 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func newExponentialHistogram(scale int32, zeroCount uint64, negativeOffset int32, negative []uint64, positiveOffset int32, positive []uint64) pmetric.ExponentialHistogramDataPoint {
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	dp.SetScale(scale)
+	dp.SetZeroCount(zeroCount)
+	dp.Negative().SetOffset(negativeOffset)
+	dp.Negative().BucketCounts().FromRaw(negative)
+	dp.Positive().SetOffset(positiveOffset)
+	dp.Positive().BucketCounts().FromRaw(positive)
+	count := zeroCount
+	for _, c := range append(append([]uint64{}, negative...), positive...) {
+		count += c
+	}
+	dp.SetCount(count)
+	return dp
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		dp   pmetric.ExponentialHistogramDataPoint
+		want []cumulativeBucket
+	}{
+		{
+			name: "empty",
+			dp:   newExponentialHistogram(0, 0, 0, nil, 0, nil),
+			want: []cumulativeBucket{{le: "0", count: 0}, {le: "+Inf", count: 0}},
+		},
+		{
+			name: "scale 0",
+			dp:   newExponentialHistogram(0, 1, 0, []uint64{3}, 0, []uint64{1, 2}),
+			want: []cumulativeBucket{
+				{le: "-1", count: 3},
+				{le: "0", count: 4},
+				{le: "2", count: 5},
+				{le: "4", count: 7},
+				{le: "+Inf", count: 7},
+			},
+		},
+		{
+			name: "negative scale and offsets",
+			dp:   newExponentialHistogram(-1, 0, 1, []uint64{1, 1}, 1, []uint64{2}),
+			want: []cumulativeBucket{
+				{le: "-16", count: 1},
+				{le: "-4", count: 2},
+				{le: "0", count: 2},
+				{le: "16", count: 4},
+				{le: "+Inf", count: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exponentialBuckets(tt.dp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exponentialBuckets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelegrafPrometheusV2Lines(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric pmetric.Metric
+		want   []testLine
+	}{
+		{
+			name:   "int and double gauge",
+			metric: newGauge("temperature", int64(20), 20.5),
+			want: []testLine{
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "temperature"}, Fields: map[string]any{"value": 20.0, "value_int": int64(20)}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "temperature"}, Fields: map[string]any{"value": 20.5}},
+			},
+		},
+		{
+			name:   "sum",
+			metric: newSum("requests", true, 3),
+			want: []testLine{
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "requests"}, Fields: map[string]any{"value": 3.0}},
+			},
+		},
+		{
+			name:   "histogram",
+			metric: newHistogram("latency", []float64{0.1}, []uint64{2, 3}, 4.5),
+			want: []testLine{
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "latency_bucket", "le": "0.1"}, Fields: map[string]any{"value": 2.0}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "latency_bucket", "le": "+Inf"}, Fields: map[string]any{"value": 5.0}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "latency_count"}, Fields: map[string]any{"value": 5.0}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "latency_sum"}, Fields: map[string]any{"value": 4.5}},
+			},
+		},
+		{
+			name:   "summary",
+			metric: newSummary("rpc", 10, 5, map[float64]float64{0.5: 0.4}, 0.5),
+			want: []testLine{
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "rpc", "quantile": "0.5"}, Fields: map[string]any{"value": 0.4}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "rpc_count"}, Fields: map[string]any{"value": 10.0}},
+				{Key: prometheusTable, Attributes: map[string]any{"__name__": "rpc_sum"}, Fields: map[string]any{"value": 5.0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testLines(telegrafPrometheusV2Lines(tt.metric)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("telegrafPrometheusV2Lines() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestSetScopeTags(t *testing.T) {
+	scope := pcommon.NewInstrumentationScope()
+	scope.SetName("otelcol/hostmetrics")
+	scope.SetVersion("1.0.0")
+
+	tests := []struct {
+		name        string
+		attributes  map[string]any
+		want        map[string]any
+		wantRenamed bool
+	}{
+		{
+			name:       "no collision",
+			attributes: map[string]any{"host": "a"},
+			want:       map[string]any{"host": "a", "scope_name": "otelcol/hostmetrics", "scope_version": "1.0.0"},
+		},
+		{
+			name:        "attribute named like a scope tag",
+			attributes:  map[string]any{"scope_name": "custom"},
+			want:        map[string]any{"attr_scope_name": "custom", "scope_name": "otelcol/hostmetrics", "scope_version": "1.0.0"},
+			wantRenamed: true,
+		},
+		{
+			name:        "renamed attribute named like another attribute",
+			attributes:  map[string]any{"scope_version": "2", "attr_scope_version": "3"},
+			want:        map[string]any{"attr_attr_scope_version": "2", "attr_scope_version": "3", "scope_name": "otelcol/hostmetrics", "scope_version": "1.0.0"},
+			wantRenamed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := MetricsSingleLine{Attributes: tt.attributes}
+			if renamed := setScopeTags(line, scope); renamed != tt.wantRenamed {
+				t.Errorf("setScopeTags() = %v, want %v", renamed, tt.wantRenamed)
+			}
+			if !reflect.DeepEqual(line.Attributes, tt.want) {
+				t.Errorf("attributes = %v, want %v", line.Attributes, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsTimestamps(t *testing.T) {
+	start, end := time.Unix(1, 0).UTC(), time.Unix(2, 0).UTC()
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, temporality := range []pmetric.AggregationTemporality{pmetric.AggregationTemporalityCumulative, pmetric.AggregationTemporalityDelta} {
+		m := metrics.AppendEmpty()
+		m.SetName(temporality.String())
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(temporality)
+		dp := sum.DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(end))
+		dp.SetIntValue(1)
+	}
+	newGauge("NoTimestamp", int64(1)).CopyTo(metrics.AppendEmpty())
+
+	// row is the part of the rows the test compares: their timestamps.
+	type row struct {
+		Timestamp time.Time
+		StartTS   any
+	}
+	tests := []struct {
+		name                string
+		zeroTimestampPolicy ZeroTimestampPolicy
+		startTimestamp      bool
+		want                map[string]row
+	}{
+		{
+			name:                "data point timestamps",
+			zeroTimestampPolicy: ZeroTimestampPolicyNow,
+			want: map[string]row{
+				"Cumulative":  {Timestamp: end},
+				"Delta":       {Timestamp: end},
+				"NoTimestamp": {},
+			},
+		},
+		{
+			name:                "start timestamps of cumulative data points",
+			zeroTimestampPolicy: ZeroTimestampPolicyNow,
+			startTimestamp:      true,
+			want: map[string]row{
+				"Cumulative":  {Timestamp: end, StartTS: start},
+				"Delta":       {Timestamp: end},
+				"NoTimestamp": {},
+			},
+		},
+		{
+			name:                "data points without a timestamp dropped",
+			zeroTimestampPolicy: ZeroTimestampPolicyDrop,
+			want: map[string]row{
+				"Cumulative": {Timestamp: end},
+				"Delta":      {Timestamp: end},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := NewMetricsRouter("db", "${metric.name}", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewOtelMetricsToDatalayers(&OtelMetricsToDatalayersConfig{
+				Writer:              newTestWriter(t, &DatalayerWritterConfig{}),
+				Schema:              MetricsSchemaTelegrafPrometheusV1,
+				ZeroTimestampPolicy: tt.zeroTimestampPolicy,
+				Router:              router,
+				StartTimestamp:      tt.startTimestamp,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]row{}
+			batches, _ := c.batches(context.Background(), md)
+			for _, batch := range batches.List() {
+				for _, r := range batch.Rows {
+					got[batch.Table] = row{Timestamp: r.Timestamp, StartTS: r.Fields["start_ts"]}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batches() rows = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMetricsRetry(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{})
+	w.client = newUnavailableClient(t)
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	router, err := NewMetricsRouter("db", "${metric.name}", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewOtelMetricsToDatalayers(&OtelMetricsToDatalayersConfig{
+		Writer:              w,
+		Schema:              MetricsSchemaTelegrafPrometheusV1,
+		ZeroTimestampPolicy: ZeroTimestampPolicyDrop,
+		Router:              router,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := pmetric.NewMetrics()
+	for _, scope := range []string{"a", "b"} {
+		sm := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+		sm.Scope().SetName(scope)
+		up := newGauge("up", int64(1))
+		up.Gauge().DataPoints().At(0).SetTimestamp(pcommon.NewTimestampFromTime(time.Unix(1, 0)))
+		up.CopyTo(sm.Metrics().AppendEmpty())
+		// Dropped, and so not retried.
+		newGauge("NoTimestamp", int64(1)).CopyTo(sm.Metrics().AppendEmpty())
+	}
+
+	err = c.WriteMetrics(context.Background(), md)
+	if err == nil || consumererror.IsPermanent(err) {
+		t.Fatalf("WriteMetrics() = %v, want a retryable error", err)
+	}
+	var retry consumererror.Metrics
+	if !errors.As(err, &retry) {
+		t.Fatalf("WriteMetrics() = %v, want the metrics to retry", err)
+	}
+	var got []string
+	rms := retry.Data().ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		sm := rms.At(i).ScopeMetrics().At(0)
+		for j := 0; j < sm.Metrics().Len(); j++ {
+			got = append(got, sm.Scope().Name()+"/"+sm.Metrics().At(j).Name())
+		}
+	}
+	if want := []string{"a/up", "b/up"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteMetrics() retries %q, want %q", got, want)
+	}
+}
+
+// splitSql splits a statement into its skeleton, the statement with its quoted identifiers and
+// literals replaced by placeholders, and its identifiers and literals, unquoted. It returns false
+// if a quoted identifier or literal is not closed.
+func splitSql(sql string) (string, []string, []string, bool) {
+	var skeleton []byte
+	var identifiers, literals []string
+	for i := 0; i < len(sql); i++ {
+		quote := sql[i]
+		if quote != '`' && quote != '\'' {
+			skeleton = append(skeleton, quote)
+			continue
+		}
+
+		var value []byte
+		closed := false
+		for i++; i < len(sql); i++ {
+			if sql[i] != quote {
+				value = append(value, sql[i])
+				continue
+			}
+			// A doubled quote is an escaped quote.
+			if i+1 < len(sql) && sql[i+1] == quote {
+				value = append(value, quote)
+				i++
+				continue
+			}
+			closed = true
+			break
+		}
+		if !closed {
+			return "", nil, nil, false
+		}
+		skeleton = append(skeleton, quote, '?', quote)
+		if quote == '`' {
+			identifiers = append(identifiers, string(value))
+		} else {
+			literals = append(literals, string(value))
+		}
+	}
+	return string(skeleton), identifiers, literals, true
+}
+
+// checkSql fails the test if the statement does not have the skeleton, identifiers and literals.
+func checkSql(t *testing.T, sql, wantSkeleton string, wantIdentifiers, wantLiterals []string) {
+	t.Helper()
+	skeleton, identifiers, literals, ok := splitSql(sql)
+	if !ok {
+		t.Fatalf("unterminated quote in %q", sql)
+	}
+	if skeleton != wantSkeleton {
+		t.Errorf("skeleton of %q = %q, want %q", sql, skeleton, wantSkeleton)
+	}
+	if !reflect.DeepEqual(identifiers, wantIdentifiers) {
+		t.Errorf("identifiers of %q = %q, want %q", sql, identifiers, wantIdentifiers)
+	}
+	if len(literals) != 0 || len(wantLiterals) != 0 {
+		if !reflect.DeepEqual(literals, wantLiterals) {
+			t.Errorf("literals of %q = %q, want %q", sql, literals, wantLiterals)
+		}
+	}
+}
+
+func FuzzAddquote(f *testing.F) {
+	for _, seed := range []string{"", "name", "a`b", "`", "``", "a'b", "x` FROM t; DROP TABLE y; --", "\\`", "日本語"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		checkSql(t, "SELECT "+addquote(v)+" FROM t", "SELECT `?` FROM t", []string{v}, nil)
+		checkSql(t, "WITH (ttl="+addSingleQuote(v)+")", "WITH (ttl='?')", nil, []string{v})
+	})
+}
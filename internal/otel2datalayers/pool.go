@@ -0,0 +1,229 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/flight/flightsql"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type LoadBalancing uint8
+
+const (
+	LoadBalancingRoundRobin LoadBalancing = iota
+	LoadBalancingLeastLoaded
+)
+
+func (lb LoadBalancing) String() string {
+	switch lb {
+	case LoadBalancingRoundRobin:
+		return "round_robin"
+	case LoadBalancingLeastLoaded:
+		return "least_loaded"
+	default:
+		panic("invalid LoadBalancing")
+	}
+}
+
+var LoadBalancings = map[string]LoadBalancing{
+	LoadBalancingRoundRobin.String():  LoadBalancingRoundRobin,
+	LoadBalancingLeastLoaded.String(): LoadBalancingLeastLoaded,
+}
+
+const (
+	// ejectionFailures is the number of consecutive transport failures after which an endpoint is ejected.
+	ejectionFailures = 3
+	// ejectionDuration is how long an ejected endpoint is not used, unless all the endpoints are ejected.
+	ejectionDuration = 30 * time.Second
+)
+
+// poolEndpoint is a Datalayers node of the pool and its health.
+type poolEndpoint struct {
+	client *Client
+	// inflight is the number of calls the endpoint is serving.
+	inflight int
+	// failures is the number of consecutive transport failures of the endpoint.
+	failures     int
+	ejectedUntil time.Time
+}
+
+// ClientPool spreads the calls over several Datalayers nodes. The nodes failing repeatedly are
+// ejected for a while, and a call failing because its node is unavailable is retried on another one.
+// It is safe for concurrent use.
+type ClientPool struct {
+	logger    *zap.Logger
+	balancing LoadBalancing
+
+	mu        sync.Mutex
+	endpoints []*poolEndpoint
+	// next is the index of the endpoint the round-robin starts from.
+	next int
+}
+
+func NewClientPool(configs []*ClientConfig, balancing LoadBalancing, logger *zap.Logger) (*ClientPool, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no endpoint to connect to")
+	}
+
+	endpoints := make([]*poolEndpoint, 0, len(configs))
+	for _, config := range configs {
+		endpoints = append(endpoints, &poolEndpoint{client: NewClient(config)})
+	}
+	return &ClientPool{
+		logger:    logger,
+		balancing: balancing,
+		endpoints: endpoints,
+	}, nil
+}
+
+// Connect connects to all the endpoints. It succeeds if at least one of them is connected,
+// the others are connected lazily by the calls made to them.
+func (pool *ClientPool) Connect(ctx context.Context) error {
+	var errs []error
+	for _, endpoint := range pool.endpoints {
+		if err := endpoint.client.Connect(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint.client.Address(), err))
+		}
+	}
+	if len(errs) < len(pool.endpoints) {
+		for _, err := range errs {
+			pool.logger.Warn("Failed to connect to Datalayers endpoint", zap.Error(err))
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Do runs fn with the client of an endpoint. If the endpoint is unavailable, fn is retried with the
+// client of another endpoint, each endpoint being tried at most once, until ctx is done.
+func (pool *ClientPool) Do(ctx context.Context, fn func(client *Client) error) error {
+	tried := make(map[*poolEndpoint]struct{}, len(pool.endpoints))
+	var err error
+	for len(tried) < len(pool.endpoints) {
+		if ctx.Err() != nil {
+			return errors.Join(err, ctx.Err())
+		}
+		endpoint := pool.acquire(tried)
+		tried[endpoint] = struct{}{}
+
+		err = fn(endpoint.client)
+		pool.release(endpoint, err)
+		if !isUnavailable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Executes the sql on a Datalayers node and returns the result as a slice of arrow records.
+func (pool *ClientPool) Execute(ctx context.Context, sql string) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := pool.Do(ctx, func(client *Client) error {
+		var err error
+		records, err = client.Execute(ctx, sql)
+		return err
+	})
+	return records, err
+}
+
+// Lists the catalogs of a Datalayers node.
+func (pool *ClientPool) GetCatalogs(ctx context.Context) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := pool.Do(ctx, func(client *Client) error {
+		var err error
+		records, err = client.GetCatalogs(ctx)
+		return err
+	})
+	return records, err
+}
+
+// Lists the tables of a Datalayers node matching the options.
+func (pool *ClientPool) GetTables(ctx context.Context, opts *flightsql.GetTablesOpts) ([]arrow.Record, error) {
+	var records []arrow.Record
+	err := pool.Do(ctx, func(client *Client) error {
+		var err error
+		records, err = client.GetTables(ctx, opts)
+		return err
+	})
+	return records, err
+}
+
+// Closes the connections to all the endpoints.
+func (pool *ClientPool) Close() error {
+	var errs []error
+	for _, endpoint := range pool.endpoints {
+		if err := endpoint.client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint.client.Address(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// acquire picks an endpoint that has not been tried yet. The ejected endpoints are only picked
+// if all the others were tried, the one ejected first being picked first.
+func (pool *ClientPool) acquire(tried map[*poolEndpoint]struct{}) *poolEndpoint {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	now := time.Now()
+	var picked, ejected *poolEndpoint
+	for i := range pool.endpoints {
+		// Starts from next, so that the round-robin and the ties of least-loaded rotate.
+		endpoint := pool.endpoints[(pool.next+i)%len(pool.endpoints)]
+		if _, ok := tried[endpoint]; ok {
+			continue
+		}
+		if now.Before(endpoint.ejectedUntil) {
+			if ejected == nil || endpoint.ejectedUntil.Before(ejected.ejectedUntil) {
+				ejected = endpoint
+			}
+			continue
+		}
+		if picked == nil || (pool.balancing == LoadBalancingLeastLoaded && endpoint.inflight < picked.inflight) {
+			picked = endpoint
+		}
+		if pool.balancing == LoadBalancingRoundRobin {
+			break
+		}
+	}
+	if picked == nil {
+		picked = ejected
+	}
+
+	pool.next = (pool.next + 1) % len(pool.endpoints)
+	picked.inflight++
+	return picked
+}
+
+// release records the result of a call made to the endpoint, ejecting it after too many
+// consecutive transport failures.
+func (pool *ClientPool) release(endpoint *poolEndpoint, err error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	endpoint.inflight--
+	if !isUnavailable(err) {
+		endpoint.failures = 0
+		return
+	}
+	endpoint.failures++
+	if endpoint.failures >= ejectionFailures {
+		endpoint.failures = 0
+		endpoint.ejectedUntil = time.Now().Add(ejectionDuration)
+		pool.logger.Warn("Ejected unavailable Datalayers endpoint",
+			zap.String("address", endpoint.client.Address()), zap.Duration("duration", ejectionDuration), zap.Error(err))
+	}
+}
+
+// isUnavailable returns true if the error means the node could not be reached, and so that the
+// call can be retried on another one.
+func isUnavailable(err error) bool {
+	return err != nil && status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,208 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestPool returns a pool of the endpoints a, b and c. The calls made to them are not sent,
+// the clients being connected lazily.
+func newTestPool(t *testing.T, balancing LoadBalancing) *ClientPool {
+	t.Helper()
+	var configs []*ClientConfig
+	for _, address := range []string{"a", "b", "c"} {
+		configs = append(configs, &ClientConfig{Address: address})
+	}
+	pool, err := NewClientPool(configs, balancing, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestPoolAcquire(t *testing.T) {
+	tests := []struct {
+		name      string
+		balancing LoadBalancing
+		next      int
+		inflight  []int
+		// ejectedFor is how long each endpoint is still ejected, none if zero.
+		ejectedFor []time.Duration
+		tried      []int
+		want       string
+	}{
+		{name: "round robin", balancing: LoadBalancingRoundRobin, want: "a"},
+		{name: "round robin rotates", balancing: LoadBalancingRoundRobin, next: 1, want: "b"},
+		{name: "round robin ignores the load", balancing: LoadBalancingRoundRobin, inflight: []int{5, 0, 0}, want: "a"},
+		{name: "round robin skips the tried endpoints", balancing: LoadBalancingRoundRobin, tried: []int{0}, want: "b"},
+		{name: "round robin skips the ejected endpoints", balancing: LoadBalancingRoundRobin, ejectedFor: []time.Duration{time.Second, 0, 0}, want: "b"},
+		{
+			name:       "ejected first when all are ejected",
+			balancing:  LoadBalancingRoundRobin,
+			ejectedFor: []time.Duration{20 * time.Second, 10 * time.Second, 30 * time.Second},
+			want:       "b",
+		},
+		{
+			name:       "ejected when the others are tried",
+			balancing:  LoadBalancingRoundRobin,
+			ejectedFor: []time.Duration{0, 0, time.Second},
+			tried:      []int{0, 1},
+			want:       "c",
+		},
+		{name: "least loaded", balancing: LoadBalancingLeastLoaded, inflight: []int{2, 0, 1}, want: "b"},
+		{name: "least loaded ties rotate", balancing: LoadBalancingLeastLoaded, next: 2, inflight: []int{1, 0, 0}, want: "c"},
+		{name: "least loaded skips the tried endpoints", balancing: LoadBalancingLeastLoaded, inflight: []int{1, 0, 2}, tried: []int{1}, want: "a"},
+		{
+			name:       "least loaded skips the ejected endpoints",
+			balancing:  LoadBalancingLeastLoaded,
+			inflight:   []int{1, 0, 1},
+			ejectedFor: []time.Duration{0, time.Second, 0},
+			want:       "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, tt.balancing)
+			pool.next = tt.next
+			for i, endpoint := range pool.endpoints {
+				if tt.inflight != nil {
+					endpoint.inflight = tt.inflight[i]
+				}
+				if tt.ejectedFor != nil && tt.ejectedFor[i] > 0 {
+					endpoint.ejectedUntil = time.Now().Add(tt.ejectedFor[i])
+				}
+			}
+			tried := map[*poolEndpoint]struct{}{}
+			for _, i := range tt.tried {
+				tried[pool.endpoints[i]] = struct{}{}
+			}
+
+			inflight := map[*poolEndpoint]int{}
+			for _, endpoint := range pool.endpoints {
+				inflight[endpoint] = endpoint.inflight
+			}
+
+			endpoint := pool.acquire(tried)
+			if got := endpoint.client.Address(); got != tt.want {
+				t.Errorf("acquire() = %s, want %s", got, tt.want)
+			}
+			if want := inflight[endpoint] + 1; endpoint.inflight != want {
+				t.Errorf("acquire() inflight = %d, want %d", endpoint.inflight, want)
+			}
+		})
+	}
+}
+
+func TestPoolAcquireRotates(t *testing.T) {
+	pool := newTestPool(t, LoadBalancingRoundRobin)
+	var got []string
+	for i := 0; i < 4; i++ {
+		endpoint := pool.acquire(nil)
+		got = append(got, endpoint.client.Address())
+		pool.release(endpoint, nil)
+	}
+	if want := []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("acquire() = %q, want %q", got, want)
+	}
+}
+
+func TestPoolRelease(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name        string
+		errs        []error
+		wantEjected bool
+	}{
+		{name: "success", errs: []error{nil, nil, nil}},
+		{name: "unavailable", errs: []error{unavailable, unavailable, unavailable}, wantEjected: true},
+		{name: "too few failures", errs: []error{unavailable, unavailable}},
+		{name: "failures not consecutive", errs: []error{unavailable, unavailable, nil, unavailable}},
+		{name: "other errors", errs: []error{status.Error(codes.Internal, "failed"), status.Error(codes.Internal, "failed"), status.Error(codes.Internal, "failed")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, LoadBalancingRoundRobin)
+			endpoint := pool.endpoints[0]
+			before := time.Now()
+			for _, err := range tt.errs {
+				endpoint.inflight++
+				pool.release(endpoint, err)
+			}
+			after := time.Now()
+
+			if endpoint.inflight != 0 {
+				t.Errorf("release() inflight = %d, want 0", endpoint.inflight)
+			}
+			if !tt.wantEjected {
+				if !endpoint.ejectedUntil.IsZero() {
+					t.Errorf("release() ejected the endpoint until %s", endpoint.ejectedUntil)
+				}
+				return
+			}
+			if endpoint.ejectedUntil.Before(before.Add(ejectionDuration)) || endpoint.ejectedUntil.After(after.Add(ejectionDuration)) {
+				t.Errorf("release() ejected the endpoint until %s, want %s from now", endpoint.ejectedUntil, ejectionDuration)
+			}
+			if endpoint.failures != 0 {
+				t.Errorf("release() failures = %d, want 0 once ejected", endpoint.failures)
+			}
+		})
+	}
+}
+
+func TestPoolDo(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	failed := status.Error(codes.Internal, "failed")
+	tests := []struct {
+		name string
+		// errs are the errors of the calls to each endpoint.
+		errs      map[string]error
+		cancelled bool
+		wantTried []string
+		wantErr   error
+	}{
+		{name: "success", wantTried: []string{"a"}},
+		{name: "fails over when unavailable", errs: map[string]error{"a": unavailable}, wantTried: []string{"a", "b"}},
+		{name: "no failover on other errors", errs: map[string]error{"a": failed}, wantTried: []string{"a"}, wantErr: failed},
+		{
+			name:      "all unavailable",
+			errs:      map[string]error{"a": unavailable, "b": unavailable, "c": unavailable},
+			wantTried: []string{"a", "b", "c"},
+			wantErr:   unavailable,
+		},
+		{name: "context done", cancelled: true, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, LoadBalancingRoundRobin)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			var tried []string
+			err := pool.Do(ctx, func(client *Client) error {
+				tried = append(tried, client.Address())
+				return tt.errs[client.Address()]
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tried, tt.wantTried) {
+				t.Errorf("Do() tried %q, want %q", tried, tt.wantTried)
+			}
+			for _, endpoint := range pool.endpoints {
+				if endpoint.inflight != 0 {
+					t.Errorf("Do() left %d calls in flight on %s", endpoint.inflight, endpoint.client.Address())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package otel2datalayers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// MetricsRoutingRule overrides the database and table of the metrics it matches.
+type MetricsRoutingRule struct {
+	// MetricName is a regular expression the metric name must match. Empty matches every metric.
+	MetricName string
+	// ResourceAttributes must all be present on the resource with the same values.
+	ResourceAttributes map[string]string
+	// Database is the database template used for the matched metrics. Empty keeps the default one.
+	Database string
+	// Table is the table template used for the matched metrics. Empty keeps the default one.
+	Table string
+}
+
+type metricsRoute struct {
+	metricName         *regexp.Regexp
+	resourceAttributes map[string]string
+	database           string
+	table              string
+}
+
+// MetricsRouter renders the database and table a metric is written to from templates.
+// A template references the metric with `${...}` variables:
+//   - ${resource.<key>}: the resource attribute <key>
+//   - ${scope.name}, ${scope.version}: the instrumentation scope
+//   - ${metric.name}, ${metric.type}, ${metric.unit}: the metric
+type MetricsRouter struct {
+	database         string
+	table            string
+	fallbackDatabase string
+	routes           []metricsRoute
+}
+
+func NewMetricsRouter(database, table, fallbackDatabase string, rules []MetricsRoutingRule) (*MetricsRouter, error) {
+	if database == "" {
+		return nil, fmt.Errorf("database template is empty")
+	}
+	if table == "" {
+		return nil, fmt.Errorf("table template is empty")
+	}
+
+	routes := make([]metricsRoute, 0, len(rules))
+	for i, rule := range rules {
+		route := metricsRoute{
+			resourceAttributes: rule.ResourceAttributes,
+			database:           rule.Database,
+			table:              rule.Table,
+		}
+		if rule.MetricName != "" {
+			re, err := regexp.Compile(rule.MetricName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metric_name of routing rule %d: %w", i, err)
+			}
+			route.metricName = re
+		}
+		routes = append(routes, route)
+	}
+
+	return &MetricsRouter{
+		database:         database,
+		table:            table,
+		fallbackDatabase: fallbackDatabase,
+		routes:           routes,
+	}, nil
+}
+
+// Route returns the database and table of the metric. The first matching rule overrides the
+// default templates. If the database template references a missing key, the fallback database
+// is used; an empty database means the metric cannot be routed. If the table template references
+// a missing key, the metric name is used.
+func (r *MetricsRouter) Route(resource pcommon.Resource, scope pcommon.InstrumentationScope, m pmetric.Metric) (string, string) {
+	database, table := r.database, r.table
+	for _, route := range r.routes {
+		if route.matches(resource, m) {
+			if route.database != "" {
+				database = route.database
+			}
+			if route.table != "" {
+				table = route.table
+			}
+			break
+		}
+	}
+
+	variables := func(name string) (string, bool) {
+		switch name {
+		case "scope.name":
+			return scope.Name(), true
+		case "scope.version":
+			return scope.Version(), true
+		case "metric.name":
+			return m.Name(), true
+		case "metric.type":
+			return strings.ToLower(m.Type().String()), true
+		case "metric.unit":
+			return m.Unit(), true
+		}
+		if key, ok := strings.CutPrefix(name, "resource."); ok {
+			if v, ok := resource.Attributes().Get(key); ok && v.AsString() != "" {
+				return v.AsString(), true
+			}
+		}
+		return "", false
+	}
+
+	database, ok := expandTemplate(database, variables)
+	if !ok || database == "" {
+		database = r.fallbackDatabase
+	}
+	table, ok = expandTemplate(table, variables)
+	if !ok || table == "" {
+		table = m.Name()
+	}
+	return database, table
+}
+
+func (route *metricsRoute) matches(resource pcommon.Resource, m pmetric.Metric) bool {
+	if route.metricName != nil && !route.metricName.MatchString(m.Name()) {
+		return false
+	}
+	for k, expected := range route.resourceAttributes {
+		v, ok := resource.Attributes().Get(k)
+		if !ok || v.AsString() != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// expandTemplate replaces the ${...} variables of the template. It returns false if a variable is unknown.
+func expandTemplate(template string, variables func(string) (string, bool)) (string, bool) {
+	complete := true
+	expanded := os.Expand(template, func(name string) string {
+		v, ok := variables(name)
+		if !ok {
+			complete = false
+		}
+		return v
+	})
+	return expanded, complete
+}
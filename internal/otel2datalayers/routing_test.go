@@ -0,0 +1,127 @@
+package otel2datalayers
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	variables := func(name string) (string, bool) {
+		switch name {
+		case "metric.name":
+			return "cpu", true
+		case "empty":
+			return "", true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		name         string
+		template     string
+		want         string
+		wantComplete bool
+	}{
+		{name: "no variable", template: "metrics", want: "metrics", wantComplete: true},
+		{name: "variable", template: "metrics_${metric.name}", want: "metrics_cpu", wantComplete: true},
+		{name: "empty variable", template: "metrics_${empty}", want: "metrics_", wantComplete: true},
+		{name: "unknown variable", template: "metrics_${resource.missing}", want: "metrics_", wantComplete: false},
+		{name: "known and unknown variables", template: "${metric.name}_${unknown}", want: "cpu_", wantComplete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, complete := expandTemplate(tt.template, variables)
+			if got != tt.want || complete != tt.wantComplete {
+				t.Errorf("expandTemplate() = %q, %v, want %q, %v", got, complete, tt.want, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestMetricsRouterRoute(t *testing.T) {
+	router, err := NewMetricsRouter("metrics_${resource.service.name}", "${metric.name}", "metrics_default", []MetricsRoutingRule{
+		{MetricName: "^http_", Database: "http", Table: "${metric.type}_${metric.unit}"},
+		{ResourceAttributes: map[string]string{"env": "test"}, Database: "test_${scope.name}"},
+		{MetricName: "^unknown_", Table: "${resource.missing}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		resource     map[string]any
+		metric       string
+		wantDatabase string
+		wantTable    string
+	}{
+		{
+			name:         "default templates",
+			resource:     map[string]any{"service.name": "api"},
+			metric:       "cpu",
+			wantDatabase: "metrics_api",
+			wantTable:    "cpu",
+		},
+		{
+			name:         "missing resource attribute uses the fallback database",
+			metric:       "cpu",
+			wantDatabase: "metrics_default",
+			wantTable:    "cpu",
+		},
+		{
+			name:         "rule matching the metric name",
+			resource:     map[string]any{"service.name": "api"},
+			metric:       "http_requests",
+			wantDatabase: "http",
+			wantTable:    "gauge_ms",
+		},
+		{
+			name:         "rule matching the resource attributes",
+			resource:     map[string]any{"env": "test"},
+			metric:       "cpu",
+			wantDatabase: "test_scope",
+			wantTable:    "cpu",
+		},
+		{
+			name:         "first matching rule applies",
+			resource:     map[string]any{"env": "test"},
+			metric:       "http_requests",
+			wantDatabase: "http",
+			wantTable:    "gauge_ms",
+		},
+		{
+			name:         "missing table key uses the metric name",
+			resource:     map[string]any{"service.name": "api"},
+			metric:       "unknown_metric",
+			wantDatabase: "metrics_api",
+			wantTable:    "unknown_metric",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := pcommon.NewResource()
+			if err := resource.Attributes().FromRaw(tt.resource); err != nil {
+				t.Fatal(err)
+			}
+			scope := pcommon.NewInstrumentationScope()
+			scope.SetName("scope")
+			m := pmetric.NewMetric()
+			m.SetName(tt.metric)
+			m.SetUnit("ms")
+			m.SetEmptyGauge()
+
+			database, table := router.Route(resource, scope, m)
+			if database != tt.wantDatabase || table != tt.wantTable {
+				t.Errorf("Route() = %q, %q, want %q, %q", database, table, tt.wantDatabase, tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestNewMetricsRouterInvalidRule(t *testing.T) {
+	if _, err := NewMetricsRouter("db", "table", "", []MetricsRoutingRule{{MetricName: "("}}); err == nil {
+		t.Error("NewMetricsRouter() with an invalid metric_name succeeded")
+	}
+}
@@ -0,0 +1,195 @@
+package otel2datalayers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/flight"
+	"github.com/apache/arrow/go/v17/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// schemaCache caches the databases, tables and columns known to exist on the server.
+// It is safe for concurrent use.
+type schemaCache struct {
+	mu sync.RWMutex
+	// databases maps a database to its tables, and a table to its columns and their types.
+	// The type of a column is nil when it is unknown or not supported, see normalizeType.
+	databases map[string]map[string]map[string]arrow.DataType
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{databases: map[string]map[string]map[string]arrow.DataType{}}
+}
+
+func (c *schemaCache) hasDatabase(db string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.databases[db]
+	return ok
+}
+
+func (c *schemaCache) addDatabase(db string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.databases[db]; !ok {
+		c.databases[db] = map[string]map[string]arrow.DataType{}
+	}
+}
+
+// removeDatabase removes the database and its tables.
+func (c *schemaCache) removeDatabase(db string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.databases, db)
+}
+
+func (c *schemaCache) hasTable(db, table string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.databases[db][table]
+	return ok
+}
+
+// setTable replaces the columns of the table.
+func (c *schemaCache) setTable(db, table string, columns map[string]arrow.DataType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.databases[db]; !ok {
+		c.databases[db] = map[string]map[string]arrow.DataType{}
+	}
+	c.databases[db][table] = columns
+}
+
+// missingColumns returns the columns the table does not have, in the given order.
+func (c *schemaCache) missingColumns(db, table string, columns []string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	known := c.databases[db][table]
+	var missing []string
+	for _, column := range columns {
+		if _, ok := known[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	return missing
+}
+
+// columnCount returns the number of tag and value columns of the table, the timestamp and the
+// extra attributes columns being excepted.
+func (c *schemaCache) columnCount(db, table string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	count := 0
+	for column := range c.databases[db][table] {
+		if column != "ts" && column != extraAttributesColumn {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *schemaCache) addColumn(db, table, column string, t arrow.DataType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if columns, ok := c.databases[db][table]; ok {
+		columns[column] = t
+	}
+}
+
+// resolveTypes returns the columns with the types they have on the server, when known, so that
+// the values are bound with the types of the existing columns.
+func (c *schemaCache) resolveTypes(db, table string, columns []Column) []Column {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	known := c.databases[db][table]
+	resolved := make([]Column, 0, len(columns))
+	for _, column := range columns {
+		if t := known[column.Name]; t != nil {
+			column.Type = t
+		}
+		resolved = append(resolved, column)
+	}
+	return resolved
+}
+
+// loadSchema fills the schema cache with the databases, tables and columns of the server, so that
+// the tables existing before a restart are not checked again with DDL statements.
+// The databases are the catalogs of the server, or the schemas when the catalog is empty.
+func (w *DatalayerWritter) loadSchema(ctx context.Context) error {
+	w.ddlMu.Lock()
+	defer w.ddlMu.Unlock()
+
+	catalogs, err := w.client.GetCatalogs(ctx)
+	if err != nil {
+		return err
+	}
+	defer releaseRecords(catalogs)
+	for _, record := range catalogs {
+		index := columnIndex(record, "catalog_name")
+		if index < 0 {
+			return fmt.Errorf("no catalog_name column in the catalogs")
+		}
+		names := record.Column(index)
+		for i := 0; i < names.Len(); i++ {
+			if names.IsValid(i) && names.ValueStr(i) != "" {
+				w.schema.addDatabase(names.ValueStr(i))
+			}
+		}
+	}
+
+	tables, err := w.client.GetTables(ctx, &flightsql.GetTablesOpts{IncludeSchema: true})
+	if err != nil {
+		return err
+	}
+	defer releaseRecords(tables)
+	for _, record := range tables {
+		if err := w.loadTables(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTables adds the tables of a GetTables result to the schema cache.
+func (w *DatalayerWritter) loadTables(record arrow.Record) error {
+	catalogIndex := columnIndex(record, "catalog_name")
+	schemaIndex := columnIndex(record, "db_schema_name")
+	tableIndex := columnIndex(record, "table_name")
+	tableSchemaIndex := columnIndex(record, "table_schema")
+	if catalogIndex < 0 || schemaIndex < 0 || tableIndex < 0 || tableSchemaIndex < 0 {
+		return fmt.Errorf("unexpected tables schema: %s", record.Schema())
+	}
+
+	catalogs, schemas, tables := record.Column(catalogIndex), record.Column(schemaIndex), record.Column(tableIndex)
+	tableSchemas, ok := record.Column(tableSchemaIndex).(*array.Binary)
+	if !ok {
+		return fmt.Errorf("unexpected table_schema type: %s", record.Column(tableSchemaIndex).DataType())
+	}
+	for i := 0; i < int(record.NumRows()); i++ {
+		db := ""
+		if catalogs.IsValid(i) {
+			db = catalogs.ValueStr(i)
+		}
+		if db == "" && schemas.IsValid(i) {
+			db = schemas.ValueStr(i)
+		}
+		if db == "" || !tables.IsValid(i) || !tableSchemas.IsValid(i) {
+			continue
+		}
+
+		schema, err := flight.DeserializeSchema(tableSchemas.Value(i), memory.DefaultAllocator)
+		if err != nil {
+			return fmt.Errorf("failed to read the schema of %s.%s: %w", db, tables.ValueStr(i), err)
+		}
+		columns := make(map[string]arrow.DataType, schema.NumFields())
+		for _, field := range schema.Fields() {
+			columns[field.Name] = normalizeType(field.Type)
+		}
+		w.schema.setTable(db, tables.ValueStr(i), columns)
+	}
+	return nil
+}
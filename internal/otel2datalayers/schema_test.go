@@ -0,0 +1,137 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/flight"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// getTablesRow is a row of the result of GetTables, a nil value being null.
+type getTablesRow struct {
+	catalog, schema, table *string
+	tableSchema            []byte
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// getTablesRecord returns the record of a GetTables result including the schemas of the tables.
+func getTablesRecord(rows []getTablesRow) arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "catalog_name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "db_schema_name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "table_name", Type: arrow.BinaryTypes.String},
+		{Name: "table_type", Type: arrow.BinaryTypes.String},
+		{Name: "table_schema", Type: arrow.BinaryTypes.Binary},
+	}, nil)
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	appendString := func(b *array.StringBuilder, v *string) {
+		if v == nil {
+			b.AppendNull()
+		} else {
+			b.Append(*v)
+		}
+	}
+	for _, row := range rows {
+		appendString(b.Field(0).(*array.StringBuilder), row.catalog)
+		appendString(b.Field(1).(*array.StringBuilder), row.schema)
+		appendString(b.Field(2).(*array.StringBuilder), row.table)
+		b.Field(3).(*array.StringBuilder).Append("TABLE")
+		if row.tableSchema == nil {
+			b.Field(4).(*array.BinaryBuilder).AppendNull()
+		} else {
+			b.Field(4).(*array.BinaryBuilder).Append(row.tableSchema)
+		}
+	}
+	return b.NewRecord()
+}
+
+func TestLoadTables(t *testing.T) {
+	cpu := flight.SerializeSchema(arrow.NewSchema([]arrow.Field{
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "usage", Type: arrow.PrimitiveTypes.Float32},
+		{Name: "count", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "up", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "buckets", Type: arrow.ListOf(arrow.PrimitiveTypes.Int64)},
+	}, nil), memory.DefaultAllocator)
+	mem := flight.SerializeSchema(arrow.NewSchema([]arrow.Field{
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+		{Name: "host", Type: arrow.BinaryTypes.LargeString},
+	}, nil), memory.DefaultAllocator)
+
+	record := getTablesRecord([]getTablesRow{
+		{catalog: ptr("db"), schema: ptr("public"), table: ptr("cpu"), tableSchema: cpu},
+		// The database is the schema when the catalog is empty.
+		{catalog: ptr(""), schema: ptr("other"), table: ptr("mem"), tableSchema: mem},
+		{catalog: nil, schema: nil, table: ptr("no_database"), tableSchema: mem},
+		{catalog: ptr("db"), schema: ptr("public"), table: ptr("no_schema")},
+	})
+	defer record.Release()
+
+	w := newTestWriter(t, &DatalayerWritterConfig{})
+	if err := w.loadTables(record); err != nil {
+		t.Fatalf("loadTables() = %v", err)
+	}
+	want := map[string]map[string]map[string]arrow.DataType{
+		"db": {"cpu": {
+			"ts":      timestampType(arrow.Millisecond),
+			"host":    arrow.BinaryTypes.String,
+			"usage":   arrow.PrimitiveTypes.Float64,
+			"count":   arrow.PrimitiveTypes.Int64,
+			"up":      arrow.FixedWidthTypes.Boolean,
+			"buckets": nil,
+		}},
+		"other": {"mem": {
+			"ts":   timestampType(arrow.Nanosecond),
+			"host": arrow.BinaryTypes.String,
+		}},
+	}
+	if !reflect.DeepEqual(w.schema.databases, want) {
+		t.Errorf("loadTables() cached %v, want %v", w.schema.databases, want)
+	}
+}
+
+func TestLoadTablesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func() arrow.Record
+	}{
+		{
+			name: "no table_schema column",
+			record: func() arrow.Record {
+				record := getTablesRecord([]getTablesRow{{catalog: ptr("db"), table: ptr("cpu")}})
+				defer record.Release()
+				schema := arrow.NewSchema(record.Schema().Fields()[:4], nil)
+				return array.NewRecord(schema, record.Columns()[:4], record.NumRows())
+			},
+		},
+		{
+			name: "invalid table schema",
+			record: func() arrow.Record {
+				return getTablesRecord([]getTablesRow{{catalog: ptr("db"), table: ptr("cpu"), tableSchema: []byte("not a schema")}})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record()
+			defer record.Release()
+
+			w := newTestWriter(t, &DatalayerWritterConfig{})
+			if err := w.loadTables(record); err == nil {
+				t.Error("loadTables() = nil, want an error")
+			}
+			if len(w.schema.databases) != 0 {
+				t.Errorf("loadTables() cached %v, want nothing", w.schema.databases)
+			}
+		})
+	}
+}
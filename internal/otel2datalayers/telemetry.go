@@ -0,0 +1,84 @@
+package otel2datalayers
+
+import (
+	"context"
+
+	"github.com/emqx-ecp-devops/datalayersgrpcexporter/internal/metadata"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// Reasons for which rows are dropped before reaching Datalayers.
+const (
+	dropReasonZeroTimestamp   = "zero_timestamp"
+	dropReasonMissingDatabase = "missing_database"
+	dropReasonShutdown        = "shutdown"
+	dropReasonSeriesLimit     = "series_limit"
+	dropReasonPermanentError  = "permanent_error"
+)
+
+// Limits of the tables lines can exceed, see limits.go.
+const (
+	limitColumnsPerTable      = "columns_per_table"
+	limitSeriesPerTable       = "series_per_table"
+	limitAttributeValueLength = "attribute_value_length"
+)
+
+// Actions taken on the lines exceeding a limit.
+const (
+	limitActionFolded    = "folded"
+	limitActionDropped   = "dropped"
+	limitActionTruncated = "truncated"
+)
+
+// exporterTelemetry reports what the exporter does with the data it cannot write.
+type exporterTelemetry struct {
+	logger       *zap.Logger
+	droppedLines metric.Int64Counter
+	limitedLines metric.Int64Counter
+}
+
+func newExporterTelemetry(settings component.TelemetrySettings) (*exporterTelemetry, error) {
+	meter := settings.MeterProvider.Meter(metadata.ScopeName)
+	droppedLines, err := meter.Int64Counter(
+		"datalayersgrpc_exporter_dropped_lines",
+		metric.WithDescription("Number of lines dropped by the exporter before being written to Datalayers, by reason."),
+		metric.WithUnit("{lines}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	limitedLines, err := meter.Int64Counter(
+		"datalayersgrpc_exporter_limited_lines",
+		metric.WithDescription("Number of lines exceeding a limit of their table, by limit and action taken."),
+		metric.WithUnit("{lines}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &exporterTelemetry{
+		logger:       settings.Logger,
+		droppedLines: droppedLines,
+		limitedLines: limitedLines,
+	}, nil
+}
+
+// recordDropped counts and logs the lines dropped for the reason.
+func (t *exporterTelemetry) recordDropped(ctx context.Context, count int, reason string, fields ...zap.Field) {
+	if count == 0 {
+		return
+	}
+	t.droppedLines.Add(ctx, int64(count), metric.WithAttributes(attribute.String("reason", reason)))
+	t.logger.Warn("Dropped lines", append([]zap.Field{zap.Int("count", count), zap.String("reason", reason)}, fields...)...)
+}
+
+// recordLimited counts and logs the lines exceeding the limit, and the action taken on them.
+func (t *exporterTelemetry) recordLimited(ctx context.Context, count int, limit, action string, fields ...zap.Field) {
+	if count == 0 {
+		return
+	}
+	t.limitedLines.Add(ctx, int64(count), metric.WithAttributes(attribute.String("limit", limit), attribute.String("action", action)))
+	t.logger.Warn("Lines exceeded a limit", append([]zap.Field{zap.Int("count", count), zap.String("limit", limit), zap.String("action", action)}, fields...)...)
+}
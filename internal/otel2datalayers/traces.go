@@ -0,0 +1,228 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// Trace describes the table a span is written to.
+type Trace struct {
+	Table string
+	// SpanDimensions are span or resource attributes used as tag columns, in addition to trace_id and span_id.
+	SpanDimensions []string
+	// SpanFields are span or resource attributes used as value columns.
+	SpanFields []string
+}
+
+type OtelTracesToDatalayersConfig struct {
+	Writer *DatalayerWritter
+	// Database is the database the span tables are created in.
+	Database    string
+	GlobalTrace Trace
+	// CustomKeyScope is the span property matched against the keys of CustomTraces.
+	// Valid values: name, kind, parent_span_id, status_code, context.trace_id, context.span_id, attributes.xxx
+	CustomKeyScope string
+	CustomTraces   map[string]Trace
+}
+
+type OtelTracesToDatalayers struct {
+	writer         *DatalayerWritter
+	database       string
+	globalTrace    Trace
+	customKeyScope string
+	customTraces   map[string]Trace
+}
+
+func NewOtelTracesToDatalayers(config *OtelTracesToDatalayersConfig) (*OtelTracesToDatalayers, error) {
+	if config.Writer == nil {
+		return nil, errors.New("writer is nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("database is empty")
+	}
+	if config.GlobalTrace.Table == "" {
+		return nil, errors.New("trace table is empty")
+	}
+	return &OtelTracesToDatalayers{
+		writer:         config.Writer,
+		database:       config.Database,
+		globalTrace:    config.GlobalTrace,
+		customKeyScope: config.CustomKeyScope,
+		customTraces:   config.CustomTraces,
+	}, nil
+}
+
+// WriteTraces converts the spans and writes them to Datalayers, returning the errors of the writes.
+// If batches failed with retryable errors, the error carries the spans of their tables only, so that
+// the exporter helper retries those spans and not the ones already written.
+func (c *OtelTracesToDatalayers) WriteTraces(ctx context.Context, td ptrace.Traces) error {
+	batches := NewBatches()
+	// tables are the keys of the tables of the spans, in the order of the spans.
+	var tables []string
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+				trace := c.traceOf(span)
+				batches.Add(c.database, trace.Table, spanRow(span, ss.Scope(), rs.Resource(), trace))
+				tables = append(tables, tableKey(c.database, trace.Table))
+			}
+		}
+	}
+
+	retry, err := c.writer.WriteBatches(ctx, batches.List())
+	if len(retry) == 0 {
+		return err
+	}
+
+	retryTables := tableKeys(retry)
+	retryTraces := ptrace.NewTraces()
+	td.CopyTo(retryTraces)
+	i := 0
+	retryTraces.ResourceSpans().RemoveIf(func(rs ptrace.ResourceSpans) bool {
+		rs.ScopeSpans().RemoveIf(func(ss ptrace.ScopeSpans) bool {
+			ss.Spans().RemoveIf(func(ptrace.Span) bool {
+				table := tables[i]
+				i++
+				return !retryTables[table]
+			})
+			return ss.Spans().Len() == 0
+		})
+		return rs.ScopeSpans().Len() == 0
+	})
+	return consumererror.NewTraces(err, retryTraces)
+}
+
+// traceOf returns the custom trace whose key matches the span, or the global trace.
+func (c *OtelTracesToDatalayers) traceOf(span ptrace.Span) Trace {
+	if len(c.customTraces) == 0 {
+		return c.globalTrace
+	}
+
+	var key string
+	switch c.customKeyScope {
+	case "name":
+		key = span.Name()
+	case "kind":
+		key = span.Kind().String()
+	case "parent_span_id":
+		key = span.ParentSpanID().String()
+	case "status_code":
+		key = span.Status().Code().String()
+	case "context.trace_id":
+		key = span.TraceID().String()
+	case "context.span_id":
+		key = span.SpanID().String()
+	default:
+		attr, ok := span.Attributes().Get(strings.TrimPrefix(c.customKeyScope, "attributes."))
+		if !ok {
+			return c.globalTrace
+		}
+		key = attr.AsString()
+	}
+
+	if trace, ok := c.customTraces[key]; ok {
+		return trace
+	}
+	return c.globalTrace
+}
+
+// spanRow converts a span to a row. The attributes not used as dimensions or fields
+// are kept in the `attributes` column as JSON.
+func spanRow(span ptrace.Span, scope pcommon.InstrumentationScope, resource pcommon.Resource, trace Trace) Row {
+	row := Row{
+		Tags: map[string]any{
+			"trace_id": span.TraceID().String(),
+			"span_id":  span.SpanID().String(),
+		},
+		Fields: map[string]any{
+			"parent_span_id":           span.ParentSpanID().String(),
+			"name":                     span.Name(),
+			"kind":                     span.Kind().String(),
+			"trace_state":              span.TraceState().AsRaw(),
+			"flags":                    int64(span.Flags()),
+			"end_time_unix_nano":       int64(span.EndTimestamp()),
+			"duration_nano":            int64(span.EndTimestamp()) - int64(span.StartTimestamp()),
+			"status_code":              span.Status().Code().String(),
+			"status_message":           span.Status().Message(),
+			"scope_name":               scope.Name(),
+			"scope_version":            scope.Version(),
+			"dropped_attributes_count": int64(span.DroppedAttributesCount()),
+			"dropped_events_count":     int64(span.DroppedEventsCount()),
+			"dropped_links_count":      int64(span.DroppedLinksCount()),
+			"events":                   spanEventsJSON(span.Events()),
+			"links":                    spanLinksJSON(span.Links()),
+		},
+	}
+
+	// A span without a start time is written with the time it is inserted at, see Row.
+	if start := span.StartTimestamp(); start != 0 {
+		row.Timestamp = start.AsTime()
+	}
+
+	used := make(map[string]struct{}, len(trace.SpanDimensions)+len(trace.SpanFields))
+	for _, k := range trace.SpanDimensions {
+		used[k] = struct{}{}
+		if v, ok := lookupAttribute(k, span.Attributes(), resource.Attributes()); ok {
+			row.Tags[k] = attributeValue(v)
+		} else {
+			row.Tags[k] = nil
+		}
+	}
+	for _, k := range trace.SpanFields {
+		used[k] = struct{}{}
+		if v, ok := lookupAttribute(k, span.Attributes(), resource.Attributes()); ok {
+			row.Fields[k] = attributeValue(v)
+		}
+	}
+	row.Fields["attributes"] = attributesJSON(span.Attributes(), used)
+
+	return row
+}
+
+// lookupAttribute looks the key up in each of the maps in turn.
+func lookupAttribute(key string, maps ...pcommon.Map) (pcommon.Value, bool) {
+	for _, m := range maps {
+		if v, ok := m.Get(key); ok {
+			return v, true
+		}
+	}
+	return pcommon.Value{}, false
+}
+
+func spanEventsJSON(events ptrace.SpanEventSlice) string {
+	raw := make([]map[string]any, 0, events.Len())
+	for i := 0; i < events.Len(); i++ {
+		event := events.At(i)
+		raw = append(raw, map[string]any{
+			"time_unix_nano":           uint64(event.Timestamp()),
+			"name":                     event.Name(),
+			"attributes":               event.Attributes().AsRaw(),
+			"dropped_attributes_count": event.DroppedAttributesCount(),
+		})
+	}
+	return toJSON(raw)
+}
+
+func spanLinksJSON(links ptrace.SpanLinkSlice) string {
+	raw := make([]map[string]any, 0, links.Len())
+	for i := 0; i < links.Len(); i++ {
+		link := links.At(i)
+		raw = append(raw, map[string]any{
+			"trace_id":                 link.TraceID().String(),
+			"span_id":                  link.SpanID().String(),
+			"trace_state":              link.TraceState().AsRaw(),
+			"flags":                    link.Flags(),
+			"attributes":               link.Attributes().AsRaw(),
+			"dropped_attributes_count": link.DroppedAttributesCount(),
+		})
+	}
+	return toJSON(raw)
+}
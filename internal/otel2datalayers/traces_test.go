@@ -0,0 +1,140 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestSpanRowTimestamp(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		start pcommon.Timestamp
+		want  time.Time
+	}{
+		{name: "start timestamp", start: pcommon.NewTimestampFromTime(start), want: start},
+		// A zero time is written with the time of the insert, not at the Unix epoch.
+		{name: "no start timestamp", start: 0, want: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := ptrace.NewSpan()
+			span.SetStartTimestamp(tt.start)
+			row := spanRow(span, pcommon.NewInstrumentationScope(), pcommon.NewResource(), Trace{Table: "spans"})
+			if !row.Timestamp.Equal(tt.want) {
+				t.Errorf("spanRow().Timestamp = %v, want %v", row.Timestamp, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceOf(t *testing.T) {
+	global := Trace{Table: "spans"}
+	custom := Trace{Table: "custom_spans"}
+	span := ptrace.NewSpan()
+	span.SetName("GET /users")
+	span.SetKind(ptrace.SpanKindServer)
+	span.SetTraceID(pcommon.TraceID{1})
+	span.SetSpanID(pcommon.SpanID{2})
+	span.SetParentSpanID(pcommon.SpanID{3})
+	span.Status().SetCode(ptrace.StatusCodeError)
+	span.Attributes().PutStr("http.route", "/users")
+
+	tests := []struct {
+		name     string
+		keyScope string
+		key      string
+		want     Trace
+	}{
+		{name: "name", keyScope: "name", key: "GET /users", want: custom},
+		{name: "kind", keyScope: "kind", key: "Server", want: custom},
+		{name: "parent span id", keyScope: "parent_span_id", key: "0300000000000000", want: custom},
+		{name: "status code", keyScope: "status_code", key: "Error", want: custom},
+		{name: "trace id", keyScope: "context.trace_id", key: "01000000000000000000000000000000", want: custom},
+		{name: "span id", keyScope: "context.span_id", key: "0200000000000000", want: custom},
+		{name: "attribute", keyScope: "attributes.http.route", key: "/users", want: custom},
+		{name: "no matching key", keyScope: "name", key: "POST /users", want: global},
+		{name: "missing attribute", keyScope: "attributes.http.method", key: "", want: global},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &OtelTracesToDatalayers{globalTrace: global, customKeyScope: tt.keyScope, customTraces: map[string]Trace{tt.key: custom}}
+			if got := c.traceOf(span); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("traceOf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpanRowColumns(t *testing.T) {
+	resource := pcommon.NewResource()
+	resource.Attributes().PutStr("service.name", "api")
+	scope := pcommon.NewInstrumentationScope()
+	scope.SetName("scope")
+
+	span := ptrace.NewSpan()
+	span.SetTraceID(pcommon.TraceID{1})
+	span.SetSpanID(pcommon.SpanID{2})
+	span.SetName("GET /users")
+	span.SetStartTimestamp(1000)
+	span.SetEndTimestamp(3000)
+	span.Attributes().PutStr("http.route", "/users")
+	span.Attributes().PutInt("http.status_code", 200)
+	span.Attributes().PutStr("other", "value")
+	event := span.Events().AppendEmpty()
+	event.SetName("exception")
+	event.SetTimestamp(2000)
+	event.Attributes().PutStr("exception.type", "Error")
+	link := span.Links().AppendEmpty()
+	link.SetTraceID(pcommon.TraceID{4})
+	link.SetSpanID(pcommon.SpanID{5})
+
+	row := spanRow(span, scope, resource, Trace{
+		Table:          "spans",
+		SpanDimensions: []string{"service.name", "http.route", "missing"},
+		SpanFields:     []string{"http.status_code"},
+	})
+
+	wantTags := map[string]any{
+		"trace_id":     "01000000000000000000000000000000",
+		"span_id":      "0200000000000000",
+		"service.name": "api",
+		"http.route":   "/users",
+		"missing":      nil,
+	}
+	if !reflect.DeepEqual(row.Tags, wantTags) {
+		t.Errorf("spanRow() tags = %v, want %v", row.Tags, wantTags)
+	}
+
+	fields := make([]string, 0, len(row.Fields))
+	for k := range row.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	wantFields := []string{
+		"attributes", "dropped_attributes_count", "dropped_events_count", "dropped_links_count", "duration_nano",
+		"end_time_unix_nano", "events", "flags", "http.status_code", "kind", "links", "name", "parent_span_id",
+		"scope_name", "scope_version", "status_code", "status_message", "trace_state",
+	}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Errorf("spanRow() fields = %q, want %q", fields, wantFields)
+	}
+
+	for k, want := range map[string]any{
+		"http.status_code": int64(200),
+		"duration_nano":    int64(2000),
+		"attributes":       `{"other":"value"}`,
+		"events":           `[{"attributes":{"exception.type":"Error"},"dropped_attributes_count":0,"name":"exception","time_unix_nano":2000}]`,
+		"links": `[{"attributes":{},"dropped_attributes_count":0,"flags":0,"span_id":"0500000000000000",` +
+			`"trace_id":"04000000000000000000000000000000","trace_state":""}]`,
+	} {
+		if got := row.Fields[k]; got != want {
+			t.Errorf("spanRow() field %s = %v, want %v", k, got, want)
+		}
+	}
+}
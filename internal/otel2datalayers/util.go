@@ -1,14 +1,17 @@
 package otel2datalayers
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/array"
 	"github.com/apache/arrow/go/v17/arrow/memory"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 )
 
 // Assumes the records contain the affected rows and prints the affected rows.
@@ -137,3 +140,52 @@ func releaseRecords(records []arrow.Record) {
 		record.Release()
 	}
 }
+
+// columnIndex returns the index of the column of the record with the name, or -1 if there is none.
+func columnIndex(record arrow.Record, name string) int {
+	for i, field := range record.Schema().Fields() {
+		if strings.EqualFold(field.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// attributeValue converts an attribute value to a column value, so that the column has the type
+// of the attribute: BIGINT for ints, DOUBLE for doubles and BOOLEAN for bools. Maps and slices are
+// stored as JSON strings, bytes as base64 strings.
+func attributeValue(v pcommon.Value) any {
+	switch v.Type() {
+	case pcommon.ValueTypeStr:
+		return v.Str()
+	case pcommon.ValueTypeInt:
+		return v.Int()
+	case pcommon.ValueTypeDouble:
+		return v.Double()
+	case pcommon.ValueTypeBool:
+		return v.Bool()
+	default:
+		return v.AsString()
+	}
+}
+
+// attributesJSON encodes the attributes as a JSON object, skipping the given keys.
+func attributesJSON(attrs pcommon.Map, skip map[string]struct{}) string {
+	raw := make(map[string]any, attrs.Len())
+	attrs.Range(func(k string, v pcommon.Value) bool {
+		if _, ok := skip[k]; !ok {
+			raw[k] = v.AsRaw()
+		}
+		return true
+	})
+	return toJSON(raw)
+}
+
+// toJSON encodes v as JSON, falling back to its default format if it cannot be encoded.
+func toJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
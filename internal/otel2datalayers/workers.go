@@ -0,0 +1,179 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.uber.org/zap"
+)
+
+// workerQueueSize is the number of batches a worker can have waiting before WriteBatches blocks.
+const workerQueueSize = 64
+
+var (
+	// errWriterNotStarted is returned when batches are written before the workers are started.
+	errWriterNotStarted = errors.New("datalayers writer is not started")
+	// errWriterShutdown is returned for the batches written after Shutdown or abandoned by it.
+	errWriterShutdown = consumererror.NewPermanent(errors.New("datalayers writer is shut down"))
+)
+
+// writeJob is a batch handed to a worker, the result of its write being sent to done.
+// The batch is written with ctx, the context of the request, so that the write stops when
+// the request is done.
+type writeJob struct {
+	ctx   context.Context
+	batch *TableBatch
+	done  chan<- writeResult
+}
+
+// writeResult is the result of the write of a batch.
+type writeResult struct {
+	batch *TableBatch
+	err   error
+}
+
+// startWorkers starts the goroutines writing the batches. Each table is assigned to a single
+// worker, so that the batches of a table are written in the order they were submitted while
+// batches of different tables are written in parallel.
+func (w *DatalayerWritter) startWorkers() {
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+
+	w.abandonCtx, w.abandon = context.WithCancel(context.Background())
+	w.jobs = make([]chan writeJob, w.numWorkers)
+	for i := range w.jobs {
+		jobs := make(chan writeJob, workerQueueSize)
+		w.jobs[i] = jobs
+		w.workersWg.Add(1)
+		go func() {
+			defer w.workersWg.Done()
+			for job := range jobs {
+				if w.abandonCtx.Err() != nil {
+					w.telemetry.recordDropped(context.Background(), len(job.batch.Rows), dropReasonShutdown,
+						zap.String("database", job.batch.DB), zap.String("table", job.batch.Table))
+					job.done <- writeResult{batch: job.batch, err: errWriterShutdown}
+					continue
+				}
+				if err := job.ctx.Err(); err != nil {
+					// The request is already done, its batches are not written.
+					job.done <- writeResult{batch: job.batch, err: err}
+					continue
+				}
+				job.done <- writeResult{batch: job.batch, err: w.write(job.ctx, job.batch)}
+			}
+		}()
+	}
+}
+
+// stopWorkers stops accepting batches and waits for the queued ones to be written. The batches
+// still queued after the timeout, or once ctx is done, are dropped.
+func (w *DatalayerWritter) stopWorkers(ctx context.Context) error {
+	w.stateMu.Lock()
+	if w.closed {
+		w.stateMu.Unlock()
+		return nil
+	}
+	w.closed = true
+	for _, jobs := range w.jobs {
+		close(jobs)
+	}
+	started := w.jobs != nil
+	w.stateMu.Unlock()
+	if !started {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		w.workersWg.Wait()
+		close(stopped)
+	}()
+
+	timeout := time.NewTimer(w.shutdownTimeout)
+	defer timeout.Stop()
+	var err error
+	select {
+	case <-stopped:
+		return nil
+	case <-timeout.C:
+		err = fmt.Errorf("timed out after %s draining the pending batches", w.shutdownTimeout)
+	case <-ctx.Done():
+		err = fmt.Errorf("stopped draining the pending batches: %w", ctx.Err())
+	}
+
+	// Drops the batches that are still queued; the ones being written fail once the client is closed.
+	w.abandon()
+	return err
+}
+
+// workerOf returns the index of the worker the table of the batch is assigned to.
+func (w *DatalayerWritter) workerOf(batch *TableBatch) int {
+	h := fnv.New32a()
+	h.Write([]byte(tableKey(batch.DB, batch.Table)))
+	return int(h.Sum32() % uint32(len(w.jobs)))
+}
+
+// submit hands the batches to their workers, waits for their writes and returns the results of the
+// failed ones. The batches that could not be handed to a worker, and the ones not written once ctx
+// is done, fail with the error that stopped them.
+func (w *DatalayerWritter) submit(ctx context.Context, batches []*TableBatch) []writeResult {
+	// The channel is large enough for the workers to never block on it, even if ctx is done.
+	done := make(chan writeResult, len(batches))
+	enqueued, err := w.enqueue(ctx, batches, done)
+
+	var failed []writeResult
+	for _, batch := range batches[enqueued:] {
+		if errors.Is(err, errWriterShutdown) {
+			w.telemetry.recordDropped(ctx, len(batch.Rows), dropReasonShutdown,
+				zap.String("database", batch.DB), zap.String("table", batch.Table))
+		}
+		failed = append(failed, writeResult{batch: batch, err: err})
+	}
+	pending := make(map[*TableBatch]bool, enqueued)
+	for _, batch := range batches[:enqueued] {
+		pending[batch] = true
+	}
+	for len(pending) > 0 {
+		select {
+		case result := <-done:
+			delete(pending, result.batch)
+			if result.err != nil {
+				failed = append(failed, result)
+			}
+		case <-ctx.Done():
+			for _, batch := range batches[:enqueued] {
+				if pending[batch] {
+					failed = append(failed, writeResult{batch: batch, err: ctx.Err()})
+				}
+			}
+			return failed
+		}
+	}
+	return failed
+}
+
+// enqueue hands the batches to their workers and returns the number of batches handed, the
+// error stopping it if not all of them were. The queues are not closed while it is running.
+func (w *DatalayerWritter) enqueue(ctx context.Context, batches []*TableBatch, done chan<- writeResult) (int, error) {
+	w.stateMu.RLock()
+	defer w.stateMu.RUnlock()
+	if w.closed {
+		return 0, errWriterShutdown
+	}
+	if len(w.jobs) == 0 {
+		return 0, errWriterNotStarted
+	}
+
+	for i, batch := range batches {
+		select {
+		case w.jobs[w.workerOf(batch)] <- writeJob{ctx: ctx, batch: batch, done: done}:
+		case <-ctx.Done():
+			return i, ctx.Err()
+		}
+	}
+	return len(batches), nil
+}
@@ -0,0 +1,190 @@
+package otel2datalayers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+)
+
+// newSeqBatch returns a batch of the table whose single row has the sequence number seq.
+func newSeqBatch(table string, seq int) *TableBatch {
+	return &TableBatch{DB: "db", Table: table, Rows: []Row{{Fields: map[string]any{"seq": seq}}}}
+}
+
+func TestWorkersTableOrder(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 4})
+	var mu sync.Mutex
+	written := map[string][]int{}
+	writing := map[string]bool{}
+	w.write = func(_ context.Context, batch *TableBatch) error {
+		mu.Lock()
+		if writing[batch.Table] {
+			t.Errorf("batches of table %s written concurrently", batch.Table)
+		}
+		writing[batch.Table] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		writing[batch.Table] = false
+		written[batch.Table] = append(written[batch.Table], batch.Rows[0].Fields["seq"].(int))
+		return nil
+	}
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	tables := []string{"a", "b", "c", "d", "e"}
+	var batches []*TableBatch
+	want := map[string][]int{}
+	for seq := 0; seq < 10; seq++ {
+		for _, table := range tables {
+			batches = append(batches, newSeqBatch(table, seq))
+			want[table] = append(want[table], seq)
+		}
+	}
+	if retry, err := w.WriteBatches(context.Background(), batches); err != nil || retry != nil {
+		t.Fatalf("WriteBatches() = %v, %v, want no error", retry, err)
+	}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("written batches = %v, want %v", written, want)
+	}
+
+	for _, table := range tables {
+		worker := w.workerOf(newSeqBatch(table, 0))
+		for seq := 1; seq < 10; seq++ {
+			if got := w.workerOf(newSeqBatch(table, seq)); got != worker {
+				t.Errorf("workerOf(%s #%d) = %d, want %d", table, seq, got, worker)
+			}
+		}
+	}
+}
+
+func TestWorkersSpreadTables(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 4})
+	w.startWorkers()
+	defer w.stopWorkers(context.Background())
+
+	workers := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		workers[w.workerOf(newSeqBatch(fmt.Sprintf("table_%d", i), 0))] = true
+	}
+	if len(workers) != 4 {
+		t.Errorf("tables assigned to %d workers, want 4", len(workers))
+	}
+}
+
+// blockWrites makes the writes of w wait for release to be closed, and returns the number of
+// batches written.
+func blockWrites(w *DatalayerWritter, release <-chan struct{}) func() int {
+	var mu sync.Mutex
+	written := 0
+	w.write = func(context.Context, *TableBatch) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		written++
+		return nil
+	}
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return written
+	}
+}
+
+// waitQueued waits for n batches to be queued to the first worker.
+func waitQueued(t *testing.T, w *DatalayerWritter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(w.jobs[0]) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d batches queued, want %d", len(w.jobs[0]), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStopWorkersDrainsQueue(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 1, ShutdownTimeout: time.Minute})
+	release := make(chan struct{})
+	written := blockWrites(w, release)
+	w.startWorkers()
+
+	batches := []*TableBatch{newSeqBatch("a", 0), newSeqBatch("b", 0), newSeqBatch("c", 0)}
+	failed := make(chan []writeResult, 1)
+	go func() { failed <- w.submit(context.Background(), batches) }()
+	// The first batch is being written, the others are queued.
+	waitQueued(t, w, len(batches)-1)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.stopWorkers(context.Background()) }()
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Errorf("stopWorkers() = %v, want nil", err)
+	}
+	if got := <-failed; len(got) != 0 {
+		t.Errorf("submit() failed %v, want none", got)
+	}
+	if got := written(); got != len(batches) {
+		t.Errorf("%d batches written, want %d", got, len(batches))
+	}
+}
+
+func TestStopWorkersAbandonsQueue(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 1, ShutdownTimeout: 10 * time.Millisecond})
+	release := make(chan struct{})
+	written := blockWrites(w, release)
+	w.startWorkers()
+
+	batches := []*TableBatch{newSeqBatch("a", 0), newSeqBatch("b", 0), newSeqBatch("c", 0)}
+	failed := make(chan []writeResult, 1)
+	go func() { failed <- w.submit(context.Background(), batches) }()
+	waitQueued(t, w, len(batches)-1)
+
+	if err := w.stopWorkers(context.Background()); err == nil {
+		t.Error("stopWorkers() = nil, want the timeout error")
+	}
+	// The batch being written completes, the queued ones are abandoned.
+	close(release)
+	w.workersWg.Wait()
+	got := <-failed
+	if len(got) != len(batches)-1 {
+		t.Fatalf("submit() failed %d batches, want %d", len(got), len(batches)-1)
+	}
+	for _, result := range got {
+		if result.err != errWriterShutdown {
+			t.Errorf("submit() failed %s with %v, want %v", result.batch.Table, result.err, errWriterShutdown)
+		}
+	}
+	if got := written(); got != 1 {
+		t.Errorf("%d batches written, want 1", got)
+	}
+}
+
+func TestWriteBatchesAfterShutdown(t *testing.T) {
+	w := newTestWriter(t, &DatalayerWritterConfig{NumWorkers: 1, ShutdownTimeout: time.Minute})
+	written := blockWrites(w, nil)
+	w.startWorkers()
+	if err := w.stopWorkers(context.Background()); err != nil {
+		t.Fatalf("stopWorkers() = %v, want nil", err)
+	}
+
+	retry, err := w.WriteBatches(context.Background(), []*TableBatch{newSeqBatch("a", 0)})
+	if len(retry) != 0 {
+		t.Errorf("WriteBatches() retries %v, want none", retry)
+	}
+	if !errors.Is(err, errWriterShutdown) || !consumererror.IsPermanent(err) {
+		t.Errorf("WriteBatches() = %v, want %v", err, errWriterShutdown)
+	}
+	if got := written(); got != 0 {
+		t.Errorf("%d batches written, want 0", got)
+	}
+}
@@ -8,213 +8,633 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/apache/arrow/go/v17/arrow"
 	"go.opentelemetry.io/collector/component"
-	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
+// DatalayerWritterConfig configures the connection to Datalayers and how the batches are written.
+type DatalayerWritterConfig struct {
+	// Endpoints are the host:port addresses of the Datalayers nodes.
+	Endpoints     []string
+	LoadBalancing LoadBalancing
+	Username      string
+	Password      string
+	PasswordFile  string
+	// GRPC configures the gRPC connections to the nodes, its Endpoint being ignored.
+	GRPC *configgrpc.ClientConfig
+	// MaxSendMsgSize and MaxRecvMsgSize are the maximum sizes of the gRPC messages, in bytes.
+	// Zero uses the gRPC defaults.
+	MaxSendMsgSize int
+	MaxRecvMsgSize int
+
+	PartitionNum    int
+	PayloadMaxLines int
+	PayloadMaxBytes int
+	// TTL is the TTL of the created tables, in hours. Defaults to 24.
+	TTL                int
+	TimestampPrecision string
+	TypeConflictPolicy TypeConflictPolicy
+	NumWorkers         int
+	ShutdownTimeout    time.Duration
+
+	// MaxColumnsPerTable is the maximum number of tag and value columns of a table. Zero disables the limit.
+	MaxColumnsPerTable int
+	// MaxSeriesPerTable is the maximum number of distinct series written to a table per SeriesInterval,
+	// the lines of the other series being dropped. Zero disables the limit.
+	MaxSeriesPerTable int
+	SeriesInterval    time.Duration
+	// MaxAttributeValueLength is the length, in bytes, the tag values are truncated to. Zero disables the limit.
+	MaxAttributeValueLength int
+	OverflowPolicy          OverflowPolicy
+}
+
 type DatalayerWritter struct {
-	clientConfig *ClientConfig
-	client       *Client
+	config *DatalayerWritterConfig
+	// client is created by Start, the connections depending on the extensions of the host.
+	client       *ClientPool
 	partitionNum int
 
 	telemetrySettings component.TelemetrySettings
+	telemetry         *exporterTelemetry
 	payloadMaxLines   int
 	payloadMaxBytes   int
 	ttl               int
+	// timestampUnit is the precision of the `ts` column and of the other timestamp columns.
+	timestampUnit arrow.TimeUnit
+	// typeConflictPolicy defines how the values not matching the types of their columns are written.
+	typeConflictPolicy TypeConflictPolicy
+
+	// schema caches the databases, tables and columns known to exist on the server.
+	schema *schemaCache
+	// ddlMu serializes the statements creating or altering databases and tables.
+	ddlMu sync.Mutex
+
+	// maxColumns, series and maxAttributeValueLength are the limits of the tables, see limits.go.
+	maxColumns              int
+	series                  *seriesLimiter
+	maxAttributeValueLength int
+	overflowPolicy          OverflowPolicy
+
+	numWorkers      int
+	shutdownTimeout time.Duration
+	// write writes a batch to the server for the workers, it is writeBatch but in tests.
+	write func(ctx context.Context, batch *TableBatch) error
+	// stateMu guards jobs and closed: the queues are only closed when no batch is being queued.
+	stateMu sync.RWMutex
+	// jobs are the queues of the workers, see startWorkers.
+	jobs      []chan writeJob
+	closed    bool
+	workersWg sync.WaitGroup
+	// abandonCtx is cancelled when the workers must drop their queued batches.
+	abandonCtx context.Context
+	abandon    context.CancelFunc
+
+	// stopConnect stops the connection attempts started by Start.
+	stopConnect context.CancelFunc
+	connectWg   sync.WaitGroup
 }
 
-func NewDatalayerWritter(host, username, password, tlsPath string, partitionNum int, port uint32, payloadMaxLines, payloadMaxBytes int,
-	telemetrySettings component.TelemetrySettings, ttl int) (*DatalayerWritter, error) {
-	clientConfig := &ClientConfig{
-		Host:     host,
-		Port:     port,
-		Username: username,
-		Password: password,
-		TlsCert:  &tlsPath,
+// Timeout of and delays between the connection attempts made by Start.
+const (
+	connectTimeout      = 10 * time.Second
+	connectInitialDelay = time.Second
+	connectMaxDelay     = time.Minute
+)
+
+func NewDatalayerWritter(config *DatalayerWritterConfig, telemetrySettings component.TelemetrySettings) (*DatalayerWritter, error) {
+	timestampUnit, ok := TimestampPrecisions[config.TimestampPrecision]
+	if !ok {
+		return nil, fmt.Errorf("invalid timestamp precision %q", config.TimestampPrecision)
+	}
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no endpoint to connect to")
 	}
 
-	c, err := MakeClient(clientConfig)
+	telemetry, err := newExporterTelemetry(telemetrySettings)
 	if err != nil {
 		return nil, err
 	}
 
+	ttl := config.TTL
 	if ttl == 0 {
 		ttl = 24
 	}
+	numWorkers := config.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+	var series *seriesLimiter
+	if config.MaxSeriesPerTable > 0 && config.SeriesInterval > 0 {
+		series = newSeriesLimiter(config.MaxSeriesPerTable, config.SeriesInterval)
+	}
 
-	return &DatalayerWritter{
-		clientConfig:      clientConfig,
-		client:            c,
-		partitionNum:      partitionNum,
-		telemetrySettings: telemetrySettings,
-		payloadMaxLines:   payloadMaxLines,
-		payloadMaxBytes:   payloadMaxBytes,
-		ttl:               ttl,
-	}, nil
+	w := &DatalayerWritter{
+		config:                  config,
+		partitionNum:            config.PartitionNum,
+		telemetrySettings:       telemetrySettings,
+		telemetry:               telemetry,
+		payloadMaxLines:         config.PayloadMaxLines,
+		payloadMaxBytes:         config.PayloadMaxBytes,
+		ttl:                     ttl,
+		timestampUnit:           timestampUnit,
+		typeConflictPolicy:      config.TypeConflictPolicy,
+		schema:                  newSchemaCache(),
+		maxColumns:              config.MaxColumnsPerTable,
+		series:                  series,
+		maxAttributeValueLength: config.MaxAttributeValueLength,
+		overflowPolicy:          config.OverflowPolicy,
+		numWorkers:              numWorkers,
+		shutdownTimeout:         config.ShutdownTimeout,
+	}
+	w.write = w.writeBatch
+	return w, nil
 }
 
 // Start implements component.StartFunc
 func (w *DatalayerWritter) Start(ctx context.Context, host component.Host) error {
-	// // TODO: to check the database and tables is existed? Create without existing.
-	// tableMap[w.table] = nil
-
-	// // Creates a database.
-	// sql := fmt.Sprintf("create database if not exists %s;", w.db)
-	// _, err := w.client.Execute(sql)
-	// if err != nil {
-	// 	fmt.Println("Failed to create database: ", err)
-	// 	return err
-	// }
-
-	// // Creates a table.
-	// sqlCreateTable := `CREATE TABLE IF NOT EXISTS %s.%s (
-	//       ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	//       instance_id STRING DEFAULT 'Unknown',
-	//       timestamp key(ts)
-	//   )
-	//   PARTITION BY HASH(%s) PARTITIONS %d
-	//   ENGINE=TimeSeries;
-	// `
-	// sql = fmt.Sprintf(sqlCreateTable, w.db, w.table, "instance_id", w.partitionNum)
-
-	// _, err = w.client.Execute(sql)
-	// if err != nil {
-	// 	fmt.Println("Failed to create table: ", err)
-	// 	return err
-	// }
-
-	//todo: 可以配置并发数
-	go w.ProcessMetrics(ctx)
-
+	client, err := w.newClientPool(host)
+	if err != nil {
+		return err
+	}
+	w.client = client
+	w.startWorkers()
+
+	// The collector starts even if Datalayers is unavailable: the writes fail with retryable
+	// errors, and so are kept in the queue, until the connection is made.
+	var connectCtx context.Context
+	connectCtx, w.stopConnect = context.WithCancel(context.Background())
+	w.connectWg.Add(1)
+	go func() {
+		defer w.connectWg.Done()
+		w.connect(connectCtx)
+	}()
 	return nil
 }
 
-var tableMap = map[string]map[string]map[string]any{} // key: db, value: tableName, value: fieldName
+// newClientPool creates the clients of the endpoints, connected with the gRPC settings.
+func (w *DatalayerWritter) newClientPool(host component.Host) (*ClientPool, error) {
+	var callOpts []grpc.CallOption
+	if w.config.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(w.config.MaxSendMsgSize))
+	}
+	if w.config.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(w.config.MaxRecvMsgSize))
+	}
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		grpcConfig := *w.config.GRPC
+		grpcConfig.Endpoint = addr
+		return grpcConfig.ToClientConn(context.Background(), host, w.telemetrySettings, grpc.WithDefaultCallOptions(callOpts...))
+	}
 
-func (w *DatalayerWritter) CheckDBAndTable(db, tableName string, partitions, fields []string, valueType int32) error {
-	if len(partitions) == 0 {
-		return errors.New("PartitionKeys is empty")
+	// Creating a connection does not connect it: this only reports the invalid settings, such as a
+	// missing authenticator, which retrying would not fix.
+	conn, err := dial(w.config.Endpoints[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC settings: %w", err)
 	}
+	conn.Close()
 
-	if _, ok := tableMap[db]; !ok {
-		// Creates a database.
-		sqlCreateDB := "CREATE DATABASE IF NOT EXISTS %s"
-		sql := fmt.Sprintf(sqlCreateDB, db)
+	headers := make(map[string]string, len(w.config.GRPC.Headers))
+	for k, v := range w.config.GRPC.Headers {
+		headers[k] = string(v)
+	}
 
-		records, err := w.client.Execute(sql)
-		if err != nil {
-			fmt.Println("Failed to create databases: ", err)
-			return err
+	clientConfigs := make([]*ClientConfig, 0, len(w.config.Endpoints))
+	for _, endpoint := range w.config.Endpoints {
+		clientConfigs = append(clientConfigs, &ClientConfig{
+			Address:      endpoint,
+			Username:     w.config.Username,
+			Password:     w.config.Password,
+			PasswordFile: w.config.PasswordFile,
+			Headers:      headers,
+			Dial:         dial,
+		})
+	}
+	return NewClientPool(clientConfigs, w.config.LoadBalancing, w.telemetrySettings.Logger)
+}
+
+// connect connects to the server, retrying with a growing delay until it succeeds or ctx is done.
+func (w *DatalayerWritter) connect(ctx context.Context) {
+	delay := connectInitialDelay
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		err := w.client.Connect(attemptCtx)
+		cancel()
+		if err == nil {
+			w.telemetry.logger.Info("Connected to Datalayers")
+			loadCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+			err := w.loadSchema(loadCtx)
+			cancel()
+			if err != nil {
+				w.telemetry.logger.Warn("Failed to load the schema from Datalayers, tables are checked when first written", zap.Error(err))
+			}
+			return
+		}
+		if errors.Is(err, errClientClosed) || ctx.Err() != nil {
+			return
 		}
-		defer releaseRecords(records)
 
-		tableMap[db] = map[string]map[string]any{}
+		w.telemetry.logger.Warn("Failed to connect to Datalayers, retrying",
+			zap.Duration("retry_in", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, connectMaxDelay)
 	}
+}
 
-	dbTables := tableMap[db]
-	if oldFieldsMap, ok := dbTables[tableName]; ok {
-		if len(oldFieldsMap) != 0 {
-			for _, partition := range partitions {
-				if _, ok := oldFieldsMap[partition]; !ok {
-					//todo: 新增字段, PartitionKey 暂时不支持动态修改
+// Shutdown implements component.ShutdownFunc. It stops accepting batches, waits up to the
+// shutdown timeout for the queued ones to be written, and closes the connection.
+func (w *DatalayerWritter) Shutdown(ctx context.Context) error {
+	if w.stopConnect != nil {
+		w.stopConnect()
+		w.connectWg.Wait()
+	}
 
-					tableMap[db][tableName][partition] = nil
-				}
-			}
-			for _, field := range fields {
-				if _, ok := oldFieldsMap[field]; !ok {
-					//新增字段
-					sqlAlterTable := "ALTER TABLE %s.%s ADD COLUMN %s STRING DEFAULT '';"
-					sql := fmt.Sprintf(sqlAlterTable, db, tableName, field)
-
-					records, err := w.client.Execute(sql)
-					if err != nil && !strings.Contains(err.Error(), "has already exist") {
-						fmt.Println("Failed to alter table: ", err)
-						return err
-					}
-					defer releaseRecords(records)
-
-					tableMap[db][tableName][field] = nil
-				}
+	err := w.stopWorkers(ctx)
+	if w.client == nil {
+		return err
+	}
+	if closeErr := w.client.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to close the client: %w", closeErr))
+	}
+	// The batches being written when the timeout expired fail now the connection is closed.
+	w.workersWg.Wait()
+	return err
+}
+
+// WriteBatches writes the batches with the workers and returns the batches to retry with the errors
+// of the failed ones. The batches failing with errors that retrying cannot fix, see classifyError,
+// are dropped and counted. If other batches failed with retryable errors, only those batches and
+// errors are returned, so that the request is neither dropped nor retried as a whole: the batches
+// already written are not written again.
+func (w *DatalayerWritter) WriteBatches(ctx context.Context, batches []*TableBatch) ([]*TableBatch, error) {
+	var retry []*TableBatch
+	var retryable, permanent []error
+	for _, result := range w.submit(ctx, batches) {
+		err := classifyError(result.err)
+		if !consumererror.IsPermanent(err) {
+			retry = append(retry, result.batch)
+			retryable = append(retryable, err)
+			continue
+		}
+		permanent = append(permanent, err)
+		// The batches dropped on shutdown are already counted by submit and the workers.
+		if !errors.Is(err, errWriterShutdown) {
+			w.telemetry.recordDropped(ctx, len(result.batch.Rows), dropReasonPermanentError,
+				zap.String("database", result.batch.DB), zap.String("table", result.batch.Table), zap.Error(err))
+		}
+	}
+	if len(retryable) > 0 {
+		return retry, errors.Join(retryable...)
+	}
+	return nil, errors.Join(permanent...)
+}
+
+// tableKeys returns the keys of the tables of the batches, see tableKey.
+func tableKeys(batches []*TableBatch) map[string]bool {
+	keys := make(map[string]bool, len(batches))
+	for _, batch := range batches {
+		keys[tableKey(batch.DB, batch.Table)] = true
+	}
+	return keys
+}
+
+// writeBatch binds the rows of the batch to a prepared INSERT statement and executes it,
+// sending at most payloadMaxLines rows per execution.
+func (w *DatalayerWritter) writeBatch(ctx context.Context, batch *TableBatch) error {
+	if len(batch.Rows) == 0 {
+		return nil
+	}
+
+	w.limitBatch(batch)
+	if len(batch.Rows) == 0 {
+		return nil
+	}
+
+	tags, fields := batch.Columns(w.timestampUnit)
+	overflow, err := w.CheckDBAndTable(ctx, batch.DB, batch.Table, tags, fields)
+	if err != nil {
+		return w.evictStaleTable(batch.DB, batch.Table, fmt.Errorf("failed to check table %s.%s: %w", batch.DB, batch.Table, err))
+	}
+	if len(overflow) > 0 {
+		tags, fields = w.foldOverflow(batch, tags, fields, overflow)
+	}
+	// The existing columns may have other types than the values of the batch.
+	tags = w.schema.resolveTypes(batch.DB, batch.Table, tags)
+	fields = w.schema.resolveTypes(batch.DB, batch.Table, fields)
+
+	record := batch.Record(tags, fields, w.timestampUnit, w.typeConflictPolicy)
+	defer record.Release()
+
+	numRows := record.NumRows()
+	chunkSize := int64(w.payloadMaxLines)
+	if chunkSize <= 0 {
+		chunkSize = numRows
+	}
+	// start is kept across the attempts, so that a node taking over a failed one does not insert
+	// the chunks already inserted again.
+	var start int64
+	err = w.client.Do(ctx, func(client *Client) error {
+		preparedStmt, err := client.Prepare(ctx, batch.InsertSql(tags, fields))
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert into %s.%s: %w", batch.DB, batch.Table, err)
+		}
+		// The statement is closed even if ctx is done.
+		defer client.ClosePrepared(context.WithoutCancel(ctx), preparedStmt)
+
+		for ; start < numRows; start += chunkSize {
+			end := min(start+chunkSize, numRows)
+			records, err := client.ExecutePrepared(ctx, preparedStmt, record.NewSlice(start, end))
+			if err != nil {
+				return fmt.Errorf("failed to insert into %s.%s: %w", batch.DB, batch.Table, err)
 			}
+			releaseRecords(records)
 		}
-	} else {
-		// Creates a table.
-		sqlCreateTable := `CREATE TABLE IF NOT EXISTS %s.%s (
-				ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-				val %s,
-				%s
-				timestamp key(ts)
-				)
-				PARTITION BY HASH(%s) PARTITIONS %d
-				ENGINE=TimeSeries
-				with (ttl='%dh')
-				`
-		fieldSql := ""
-		partitionKeys := ""
-		for _, partition := range partitions {
-			fieldSql += fmt.Sprintf("%s STRING DEFAULT '',", partition)
-			partitionKeys += fmt.Sprintf("%s,", partition)
-		}
-		for _, field := range fields {
-			fieldSql += fmt.Sprintf("%s STRING DEFAULT '',", field)
-		}
-		partitionKeys = strings.TrimSuffix(partitionKeys, ",")
-
-		sql := fmt.Sprintf(sqlCreateTable, db, tableName, tableTypeString(valueType), fieldSql, partitionKeys, w.partitionNum, w.ttl)
-
-		records, err := w.client.Execute(sql)
+		return nil
+	})
+	return w.evictStaleTable(batch.DB, batch.Table, err)
+}
+
+// evictStaleTable evicts the database of the table from the schema cache when err is a schema error,
+// see isSchemaError, while the table is cached: the table or its database may have been dropped or
+// altered on the server since they were cached. The error is then marked with errStaleSchema, so
+// that the batch is retried and the table checked again instead of every later batch being dropped.
+func (w *DatalayerWritter) evictStaleTable(db, table string, err error) error {
+	if err == nil || !isSchemaError(err) || !w.schema.hasTable(db, table) {
+		return err
+	}
+	w.schema.removeDatabase(db)
+	w.telemetry.logger.Warn("Table changed on the server, checking it again",
+		zap.String("database", db), zap.String("table", table), zap.Error(err))
+	return fmt.Errorf("%w: %w", errStaleSchema, err)
+}
+
+// CheckDBAndTable makes sure the database and the table exist with the given columns, creating
+// or altering them if needed. The columns over the column limit of the table are not added but
+// returned, see limitColumns. Only the DDL statements are serialized, the cached schema is shared.
+func (w *DatalayerWritter) CheckDBAndTable(ctx context.Context, db, tableName string, partitions []Column, fields []Column) ([]Column, error) {
+	if len(partitions) == 0 {
+		return nil, errEmptyPartitionKeys
+	}
+
+	if w.schema.hasTable(db, tableName) {
+		partitions, fields, overflow := w.limitColumns(db, tableName, partitions, fields)
+		if len(w.schema.missingColumns(db, tableName, w.requiredColumns(partitions, fields, overflow))) == 0 {
+			return overflow, nil
+		}
+	}
+
+	w.ddlMu.Lock()
+	defer w.ddlMu.Unlock()
+
+	if !w.schema.hasDatabase(db) {
+		// Creates a database.
+		records, err := w.client.Execute(ctx, createDatabaseSql(db))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create database %s: %w", db, err)
+		}
+		releaseRecords(records)
+
+		w.schema.addDatabase(db)
+	}
+
+	if !w.schema.hasTable(db, tableName) {
+		// Creates a table, without the columns over the limit but with at least a partition key.
+		createdPartitions, createdFields, _ := w.limitColumns(db, tableName, partitions, fields)
+		if len(createdPartitions) == 0 {
+			createdPartitions = partitions[:1]
+		}
+
+		records, err := w.client.Execute(ctx, w.createTableSql(db, tableName, createdPartitions, createdFields))
 		if err != nil {
-			fmt.Println("Failed to create table: ", err)
-			return err
+			return nil, fmt.Errorf("failed to create table %s.%s: %w", db, tableName, err)
 		}
-		defer releaseRecords(records)
+		releaseRecords(records)
 
-		columns, err := w.getColumnNames(db, tableName)
+		// The table may already exist with other columns, e.g. created by another collector:
+		// the cache is filled from the server.
+		columns, err := w.getColumns(ctx, db, tableName)
 		if err != nil {
-			fmt.Println("get colmuns failed, err: ", err)
-			return err
+			return nil, fmt.Errorf("failed to get columns of %s.%s: %w", db, tableName, err)
 		}
 
-		tableMap[db][tableName] = columns
+		w.schema.setTable(db, tableName, columns)
+	}
+
+	partitions, fields, overflow := w.limitColumns(db, tableName, partitions, fields)
+
+	// The partition keys of a table cannot be changed once it is created: the new tags are added
+	// as regular columns, still written and queryable but not used for partitioning.
+	missingPartitions := map[string]struct{}{}
+	for _, name := range w.schema.missingColumns(db, tableName, columnNames(partitions)) {
+		missingPartitions[name] = struct{}{}
+	}
+	for _, partition := range partitions {
+		if _, ok := missingPartitions[partition.Name]; !ok {
+			continue
+		}
+		if err := w.addColumn(ctx, db, tableName, partition); err != nil {
+			return nil, err
+		}
+		w.telemetry.logger.Info("Added tag as a regular column, the partition keys of an existing table cannot be changed",
+			zap.String("database", db), zap.String("table", tableName), zap.String("column", partition.Name))
+	}
+
+	missingFields := map[string]struct{}{}
+	for _, name := range w.schema.missingColumns(db, tableName, columnNames(fields)) {
+		missingFields[name] = struct{}{}
+	}
+	for _, field := range fields {
+		if _, ok := missingFields[field.Name]; !ok {
+			continue
+		}
+		if err := w.addColumn(ctx, db, tableName, field); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(overflow) > 0 && w.overflowPolicy == OverflowPolicyFold &&
+		len(w.schema.missingColumns(db, tableName, []string{extraAttributesColumn})) > 0 {
+		if err := w.addColumn(ctx, db, tableName, Column{Name: extraAttributesColumn, Type: arrow.BinaryTypes.String}); err != nil {
+			return nil, err
+		}
+	}
+
+	return overflow, nil
+}
+
+// requiredColumns returns the names of the columns the table must have to write the columns,
+// including the extra attributes column if the overflow is folded into it.
+func (w *DatalayerWritter) requiredColumns(partitions, fields, overflow []Column) []string {
+	names := columnNames(partitions, fields)
+	if len(overflow) > 0 && w.overflowPolicy == OverflowPolicyFold {
+		names = append(names, extraAttributesColumn)
+	}
+	return names
+}
+
+// addColumn adds the column to the table, unless it already exists on the server.
+func (w *DatalayerWritter) addColumn(ctx context.Context, db, tableName string, column Column) error {
+	records, err := w.client.Execute(ctx, addColumnSql(db, tableName, column))
+	if err != nil && !strings.Contains(err.Error(), "has already exist") {
+		return fmt.Errorf("failed to alter table %s.%s: %w", db, tableName, err)
 	}
+	releaseRecords(records)
 
+	// If the column already existed, its type is not known.
+	if err != nil {
+		w.schema.addColumn(db, tableName, column.Name, nil)
+	} else {
+		w.schema.addColumn(db, tableName, column.Name, column.Type)
+	}
 	return nil
 }
 
-func (w *DatalayerWritter) getColumnNames(db, table string) (map[string]any, error) {
+// getColumns returns the columns of the table and their types, see schemaCache.
+func (w *DatalayerWritter) getColumns(ctx context.Context, db, table string) (map[string]arrow.DataType, error) {
 	sql := "DESCRIBE %s.%s"
-	sql = fmt.Sprintf(sql, db, table)
-	records, err := w.client.Execute(sql)
+	sql = fmt.Sprintf(sql, addquote(db), addquote(table))
+	records, err := w.client.Execute(ctx, sql)
 	if err != nil {
 		return nil, err
 	}
 	defer releaseRecords(records)
+	return describeColumns(records)
+}
 
-	var columnNames = map[string]any{}
-	schema := records[0].Schema()
-	for _, field := range schema.Fields() {
-		columnNames[addquote(field.Name)] = nil
+// describeColumns returns the columns of a DESCRIBE result and their types. DESCRIBE returns a row
+// per column, its name being in the `column_name` column and its type in the `data_type` column.
+func describeColumns(records []arrow.Record) (map[string]arrow.DataType, error) {
+	var columns = map[string]arrow.DataType{}
+	for _, record := range records {
+		nameIndex := columnIndex(record, "column_name")
+		if nameIndex < 0 {
+			return nil, fmt.Errorf("no column_name column in the DESCRIBE result: %s", record.Schema())
+		}
+		typeIndex := columnIndex(record, "data_type")
+		names := record.Column(nameIndex)
+		for i := 0; i < names.Len(); i++ {
+			if !names.IsValid(i) {
+				continue
+			}
+			var t arrow.DataType
+			if typeIndex >= 0 && record.Column(typeIndex).IsValid(i) {
+				t = parseColumnType(record.Column(typeIndex).ValueStr(i))
+			}
+			columns[names.ValueStr(i)] = t
+		}
 	}
-	return columnNames, nil
+	return columns, nil
 }
 
-// tableTypeString returns the string representation of the metric type
-// todo: 需确认类型映射是否正确
-func tableTypeString(t int32) string {
-	switch t {
-	case int32(pmetric.MetricTypeGauge):
-		return "DOUBLE"
-	case int32(pmetric.MetricTypeSum):
-		return "DOUBLE"
-	case int32(pmetric.MetricTypeHistogram):
-		return "DOUBLE"
-	case int32(pmetric.MetricTypeSummary):
-		return "DOUBLE"
-	case int32(pmetric.MetricTypeExponentialHistogram):
+func parseColumnType(s string) arrow.DataType {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	switch {
+	case strings.HasPrefix(upper, "TIMESTAMP"):
+		switch {
+		case strings.Contains(upper, "(9") || strings.Contains(upper, "NANOSECOND"):
+			return timestampType(arrow.Nanosecond)
+		case strings.Contains(upper, "(6") || strings.Contains(upper, "MICROSECOND"):
+			return timestampType(arrow.Microsecond)
+		case strings.Contains(upper, "(3") || strings.Contains(upper, "MILLISECOND"):
+			return timestampType(arrow.Millisecond)
+		case strings.Contains(upper, "(0") || strings.Contains(upper, "SECOND"):
+			return timestampType(arrow.Second)
+		default:
+			return timestampType(arrow.Millisecond)
+		}
+	case strings.HasPrefix(upper, "BOOL"):
+		return arrow.FixedWidthTypes.Boolean
+	case strings.Contains(upper, "INT"):
+		return arrow.PrimitiveTypes.Int64
+	case strings.HasPrefix(upper, "FLOAT"), strings.HasPrefix(upper, "DOUBLE"),
+		strings.HasPrefix(upper, "REAL"), strings.HasPrefix(upper, "DECIMAL"):
+		return arrow.PrimitiveTypes.Float64
+	case strings.HasPrefix(upper, "STRING"), strings.Contains(upper, "UTF8"),
+		strings.Contains(upper, "CHAR"), strings.HasPrefix(upper, "TEXT"):
+		return arrow.BinaryTypes.String
+	default:
+		return nil
+	}
+}
+
+// createDatabaseSql returns the statement creating the database.
+func createDatabaseSql(db string) string {
+	sqlCreateDB := "CREATE DATABASE IF NOT EXISTS %s"
+	return fmt.Sprintf(sqlCreateDB, addquote(db))
+}
+
+// createTableSql returns the statement creating the table with the columns, the tags being its
+// partition keys.
+func (w *DatalayerWritter) createTableSql(db, table string, partitions, fields []Column) string {
+	sqlCreateTable := `CREATE TABLE IF NOT EXISTS %s.%s (
+			ts %s NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			%s
+			timestamp key(ts)
+			)
+			PARTITION BY HASH(%s) PARTITIONS %d
+			ENGINE=TimeSeries
+			with (ttl=%s)
+			`
+	fieldSql := ""
+	partitionKeys := ""
+	for _, partition := range partitions {
+		fieldSql += fmt.Sprintf("%s %s,", addquote(partition.Name), columnDefinition(partition.Type))
+		partitionKeys += fmt.Sprintf("%s,", addquote(partition.Name))
+	}
+	for _, field := range fields {
+		fieldSql += fmt.Sprintf("%s %s,", addquote(field.Name), columnDefinition(field.Type))
+	}
+	partitionKeys = strings.TrimSuffix(partitionKeys, ",")
+
+	return fmt.Sprintf(sqlCreateTable, addquote(db), addquote(table), tableTypeString(timestampType(w.timestampUnit)), fieldSql, partitionKeys, w.partitionNum, addSingleQuote(fmt.Sprintf("%dh", w.ttl)))
+}
+
+// addColumnSql returns the statement adding the column to the table.
+func addColumnSql(db, table string, column Column) string {
+	sqlAlterTable := "ALTER TABLE %s.%s ADD COLUMN %s %s;"
+	return fmt.Sprintf(sqlAlterTable, addquote(db), addquote(table), addquote(column.Name), columnDefinition(column.Type))
+}
+
+// tableTypeString returns the Datalayers column type of an arrow type.
+func tableTypeString(t arrow.DataType) string {
+	switch t.ID() {
+	case arrow.FLOAT64:
 		return "DOUBLE"
+	case arrow.INT64:
+		return "BIGINT"
+	case arrow.BOOL:
+		return "BOOLEAN"
+	case arrow.TIMESTAMP:
+		switch t.(*arrow.TimestampType).Unit {
+		case arrow.Second:
+			return "TIMESTAMP(0)"
+		case arrow.Microsecond:
+			return "TIMESTAMP(6)"
+		case arrow.Nanosecond:
+			return "TIMESTAMP(9)"
+		default:
+			return "TIMESTAMP(3)"
+		}
 	default:
 		return "STRING"
 	}
 }
+
+// columnDefinition returns the type and default value used to create a value column.
+func columnDefinition(t arrow.DataType) string {
+	if t.ID() == arrow.STRING {
+		return "STRING DEFAULT ''"
+	}
+	return tableTypeString(t)
+}
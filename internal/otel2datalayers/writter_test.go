@@ -0,0 +1,120 @@
+package otel2datalayers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func FuzzDDLSql(f *testing.F) {
+	f.Add("db", "table", "host", "value")
+	f.Add("d`b", "t`` able", "`) ENGINE=x; DROP TABLE y; --", "v'alue")
+	f.Add("", "`", "``", "'")
+	f.Fuzz(func(t *testing.T, db, table, tag, field string) {
+		w := &DatalayerWritter{timestampUnit: arrow.Millisecond, partitionNum: 8, ttl: 24}
+		tags := []Column{{Name: tag, Type: arrow.BinaryTypes.String}}
+		fields := []Column{{Name: field, Type: arrow.PrimitiveTypes.Float64}}
+
+		checkSql(t, createDatabaseSql(db), "CREATE DATABASE IF NOT EXISTS `?`", []string{db}, nil)
+
+		reference := w.createTableSql("db", "table", []Column{{Name: "host", Type: arrow.BinaryTypes.String}},
+			[]Column{{Name: "value", Type: arrow.PrimitiveTypes.Float64}})
+		skeleton, _, _, _ := splitSql(reference)
+		checkSql(t, w.createTableSql(db, table, tags, fields), skeleton, []string{db, table, tag, field, tag}, []string{"", "24h"})
+
+		checkSql(t, addColumnSql(db, table, fields[0]), "ALTER TABLE `?`.`?` ADD COLUMN `?` DOUBLE;", []string{db, table, field}, nil)
+		checkSql(t, addColumnSql(db, table, tags[0]), "ALTER TABLE `?`.`?` ADD COLUMN `?` STRING DEFAULT '?';", []string{db, table, tag}, []string{""})
+	})
+}
+
+// stringRecord returns a record of string columns, its rows being given as JSON.
+func stringRecord(t *testing.T, columns []string, rows string) arrow.Record {
+	t.Helper()
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, arrow.Field{Name: column, Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	record, _, err := array.RecordFromJSON(memory.DefaultAllocator, arrow.NewSchema(fields, nil), strings.NewReader(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestDescribeColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		records func(t *testing.T) []arrow.Record
+		want    map[string]arrow.DataType
+		wantErr bool
+	}{
+		{
+			name: "columns",
+			records: func(t *testing.T) []arrow.Record {
+				return []arrow.Record{
+					stringRecord(t, []string{"column_name", "data_type", "is_nullable"}, `[
+						{"column_name": "ts", "data_type": "Timestamp(Millisecond, None)", "is_nullable": "NO"},
+						{"column_name": "host", "data_type": "Utf8", "is_nullable": "YES"},
+						{"column_name": null, "data_type": "Int64", "is_nullable": "YES"}
+					]`),
+					stringRecord(t, []string{"column_name", "data_type", "is_nullable"}, `[
+						{"column_name": "value", "data_type": "Float64", "is_nullable": "YES"},
+						{"column_name": "payload", "data_type": "Binary", "is_nullable": "YES"},
+						{"column_name": "count", "data_type": null, "is_nullable": "YES"}
+					]`),
+				}
+			},
+			want: map[string]arrow.DataType{
+				"ts":      timestampType(arrow.Millisecond),
+				"host":    arrow.BinaryTypes.String,
+				"value":   arrow.PrimitiveTypes.Float64,
+				"payload": nil,
+				"count":   nil,
+			},
+		},
+		{
+			name: "column names in upper case",
+			records: func(t *testing.T) []arrow.Record {
+				return []arrow.Record{stringRecord(t, []string{"COLUMN_NAME", "DATA_TYPE"}, `[{"COLUMN_NAME": "host", "DATA_TYPE": "STRING"}]`)}
+			},
+			want: map[string]arrow.DataType{"host": arrow.BinaryTypes.String},
+		},
+		{
+			name: "no data_type column",
+			records: func(t *testing.T) []arrow.Record {
+				return []arrow.Record{stringRecord(t, []string{"column_name"}, `[{"column_name": "host"}]`)}
+			},
+			want: map[string]arrow.DataType{"host": nil},
+		},
+		{
+			name: "no column_name column",
+			records: func(t *testing.T) []arrow.Record {
+				return []arrow.Record{stringRecord(t, []string{"field", "data_type"}, `[{"field": "host", "data_type": "Utf8"}]`)}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no rows",
+			records: func(*testing.T) []arrow.Record { return nil },
+			want:    map[string]arrow.DataType{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.records(t)
+			defer releaseRecords(records)
+
+			got, err := describeColumns(records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("describeColumns() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("describeColumns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}